@@ -0,0 +1,143 @@
+// Anime style shows, where episodes are numbered 1..n instead of
+// by season and episode.
+//
+// Absolute episode numbers are mapped to seasons using, in order:
+//
+// - the `absolute-episodes.txt' file in the show's directory. It has
+//   lines with "season first-episode [last-episode]", like "2 13 25".
+// - the <season> and <episode> elements of the episode NFO file.
+// - the season directory the episode is in.
+// Otherwise the episode ends up in season 1.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const absoluteMapFile = "absolute-episodes.txt"
+
+// A range of absolute episode numbers that make up a season.
+type absSeason struct {
+	season	int
+	first	int
+	last	int
+}
+
+type absMap []absSeason
+
+func readAbsoluteMap(dir string) (m absMap) {
+	fh, err := vfsOpen(path.Join(dir, absoluteMapFile))
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		a := absSeason{
+			season: parseInt(f[0]),
+			first: parseInt(f[1]),
+		}
+		if len(f) > 2 {
+			a.last = parseInt(f[2])
+		}
+		if a.first > 0 {
+			m = append(m, a)
+		}
+	}
+	sort.Slice(m, func(i, j int) bool { return m[i].first < m[j].first })
+
+	// an open range ends where the next one starts.
+	for i := range m {
+		if m[i].last == 0 && i < len(m) - 1 {
+			m[i].last = m[i+1].first - 1
+		}
+	}
+	return
+}
+
+// absolute episode number -> season, episode.
+func (m absMap) seasonEpisode(abs int) (season int, episode int, ok bool) {
+	for _, a := range m {
+		if abs >= a.first && (a.last == 0 || abs <= a.last) {
+			season = a.season
+			episode = abs - a.first + 1
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+// season, episode -> absolute episode number.
+func (m absMap) absolute(season int, episode int) (abs int) {
+	for _, a := range m {
+		if a.season != season {
+			continue
+		}
+		abs = a.first + episode - 1
+		if a.last > 0 && abs > a.last {
+			abs = 0
+		}
+		return
+	}
+	return
+}
+
+// Put episodes with an absolute episode number in the right season.
+func mapAbsoluteEpisodes(coll *Collection, dir string, show *Item) {
+	if coll.Type == "anime" {
+		show.EpisodeOrder = "absolute"
+	}
+
+	m := readAbsoluteMap(dir)
+	var eps []Episode
+	remap := false
+	for _, s := range show.Seasons {
+		for _, ep := range s.Episodes {
+			eps = append(eps, ep)
+			if ep.AbsoluteNo > 0 || len(m) > 0 {
+				remap = true
+			}
+		}
+	}
+	if !remap {
+		return
+	}
+
+	for i := range show.Seasons {
+		show.Seasons[i].Episodes = nil
+	}
+	for _, ep := range eps {
+		if ep.AbsoluteNo == 0 {
+			// numbered by season, but we can find the absolute number.
+			ep.AbsoluteNo = m.absolute(ep.SeasonNo, ep.EpisodeNo)
+		} else if sn, en, ok := m.seasonEpisode(ep.AbsoluteNo); ok {
+			ep.SeasonNo = sn
+			ep.EpisodeNo = en
+			ep.Name = fmt.Sprintf("%02dx%02d", sn, en)
+		} else if ep.NfoPath != "" {
+			nfo := loadNfo(ep.NfoPath)
+			if nfo != nil && nfo.Season != "" && nfo.Episode != "" {
+				ep.SeasonNo = parseInt(nfo.Season)
+				ep.EpisodeNo = parseInt(nfo.Episode)
+				ep.Name = fmt.Sprintf("%02dx%02d",
+						ep.SeasonNo, ep.EpisodeNo)
+			}
+		}
+		season := getSeason(show, ep.SeasonNo)
+		season.Episodes = append(season.Episodes, ep)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"time"
 	"encoding/json"
 	"net/http"
@@ -36,7 +39,8 @@ func preCheck(w http.ResponseWriter, r *http.Request, keys ...string) (done bool
 
 func setheaders(h http.Header) {
 	h.Set("Access-Control-Allow-Origin", "*")
-	h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+	h.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS, PATCH, POST")
+	h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 }
 
 func serveJSON(obj interface{}, w http.ResponseWriter) {
@@ -53,6 +57,7 @@ func collectionsHandler(w http.ResponseWriter, r *http.Request) {
 	cc := []Collection{}
 	for _, c := range config.Collections {
 		c.Items = nil
+		c.Type = c.apiType()
 		cc = append(cc, c)
 	}
 	serveJSON(cc, w)
@@ -70,6 +75,7 @@ func collectionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	cc := *c
 	cc.Items = []*Item{}
+	cc.Type = c.apiType()
 	serveJSON(cc, w)
 }
 
@@ -86,8 +92,8 @@ func itemsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var lastVideo int64
 	for i := range c.Items {
-		if c.Items[i].LastVideo > lastVideo {
-			lastVideo = c.Items[i].LastVideo
+		if ts := c.Items[i].modTime(); ts > lastVideo {
+			lastVideo = ts
 		}
 	}
 	if lastVideo > 0 && checkEtagObj(w, r, time.UnixMilli(lastVideo)) {
@@ -97,12 +103,22 @@ func itemsHandler(w http.ResponseWriter, r *http.Request) {
 		return;
 	}
 
+	// only the items in one folder?
+	r.ParseForm()
+	_, byParent := r.Form["parent"]
+	parent := r.Form.Get("parent")
+
 	// copy items
-	items := make([]Item, len(c.Items))
+	items := make([]Item, 0, len(c.Items))
 	for i := range c.Items {
-		items[i] = *c.Items[i]
-		items[i].Seasons = []Season{}
-		items[i].Nfo = nil
+		if byParent && c.Items[i].Parent != parent {
+			continue
+		}
+		item := *c.Items[i]
+		item.Seasons = []Season{}
+		item.Children = nil
+		item.Nfo = nil
+		items = append(items, item)
 	}
 
 	// hack to show empty items list here.
@@ -124,7 +140,8 @@ func itemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if i.LastVideo > 0 && checkEtagObj(w, r, time.UnixMilli(i.LastVideo)) {
+	ts := i.modTime()
+	if ts > 0 && checkEtagObj(w, r, time.UnixMilli(ts)) {
 		return
 	}
 	if r.Method == "HEAD" {
@@ -141,10 +158,18 @@ func itemHandler(w http.ResponseWriter, r *http.Request) {
 	// nfo details to hang around in memory.
 	i2 := *i
 	if doNfo && i2.NfoPath != "" {
-		file, err := os.Open(i2.NfoPath)
-		if err == nil {
-			i2.Nfo = decodeNfo(file)
-			file.Close()
+		i2.Nfo = loadNfo(i2.NfoPath)
+	}
+
+	// In case of a folder, list the children, but not their details.
+	if len(i.Children) > 0 {
+		i2.Children = make([]*Item, len(i.Children))
+		for ci, child := range i.Children {
+			c2 := *child
+			c2.Seasons = nil
+			c2.Children = nil
+			c2.Nfo = nil
+			i2.Children[ci] = &c2
 		}
 	}
 
@@ -156,13 +181,8 @@ func itemHandler(w http.ResponseWriter, r *http.Request) {
 			ep := i2.Seasons[si].Episodes[ei]
 			if doNfo {
 				if ep.NfoPath != "" {
-					file, err := os.Open(ep.NfoPath)
-					if err == nil {
-						ep2 := ep
-						ep2.Nfo = decodeNfo(file)
-						file.Close()
-						i2.Seasons[si].Episodes[ei] = ep2
-					}
+					ep.Nfo = loadNfo(ep.NfoPath)
+					i2.Seasons[si].Episodes[ei] = ep
 				}
 			}
 		}
@@ -171,6 +191,409 @@ func itemHandler(w http.ResponseWriter, r *http.Request) {
 	serveJSON(&i2, w)
 }
 
+var isAirDate = regexp.MustCompile(`^([0-9]{4})[.-]?([0-9]{2})[.-]?([0-9]{2})$`)
+
+// Look up an episode of a date-based show by its air date.
+func episodeByDateHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll", "item", "date") {
+		return
+	}
+	vars := mux.Vars(r)
+	i := getItem(vars["coll"], vars["item"])
+	d := isAirDate.FindStringSubmatch(vars["date"])
+	if i == nil || d == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	ep := getEpisodeByDate(i, d[1] + "-" + d[2] + "-" + d[3])
+	if ep == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	ep2 := *ep
+	if ep2.NfoPath != "" {
+		ep2.Nfo = loadNfo(ep2.NfoPath)
+	}
+	serveJSON(&ep2, w)
+}
+
+// One season of a show, by number or id, with the NFO of its episodes.
+func seasonHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll", "item", "season") {
+		return
+	}
+	vars := mux.Vars(r)
+	i := getItem(vars["coll"], vars["item"])
+	var s *Season
+	if i != nil {
+		s = getSeasonByKey(i, vars["season"])
+	}
+	if s == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	ts := i.modTime()
+	if ts > 0 && checkEtagObj(w, r, time.UnixMilli(ts)) {
+		return
+	}
+	if r.Method == "HEAD" {
+		return;
+	}
+
+	r.ParseForm();
+	_, noNfo := r.Form["nonfo"]
+
+	s2 := *s
+	s2.Episodes = make([]Episode, len(s.Episodes))
+	copy(s2.Episodes, s.Episodes)
+	for ei := range s2.Episodes {
+		ep := &(s2.Episodes[ei])
+		if !noNfo && ep.NfoPath != "" {
+			ep.Nfo = loadNfo(ep.NfoPath)
+		}
+	}
+	serveJSON(&s2, w)
+}
+
+// One episode of a show, by id.
+func episodeHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll", "item", "episode") {
+		return
+	}
+	vars := mux.Vars(r)
+	i := getItem(vars["coll"], vars["item"])
+	var ep *Episode
+	if i != nil {
+		ep = getEpisodeById(i, vars["episode"])
+	}
+	if ep == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	ts := ep.VideoTS
+	if ep.NfoTime > ts {
+		ts = ep.NfoTime
+	}
+	if ts > 0 && checkEtagObj(w, r, time.UnixMilli(ts)) {
+		return
+	}
+	if r.Method == "HEAD" {
+		return;
+	}
+
+	ep2 := *ep
+	if ep2.NfoPath != "" {
+		ep2.Nfo = loadNfo(ep2.NfoPath)
+	}
+	serveJSON(&ep2, w)
+}
+
+// Missing and duplicate episodes of one show.
+func itemMissingHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll", "item") {
+		return
+	}
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	i := getItem(vars["coll"], vars["item"])
+	if c == nil || i == nil || i.Type != "show" {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	serveJSON(showMissingReport(c, i), w)
+}
+
+// Shows of a collection with missing or duplicate episodes.
+func collectionMissingHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll") {
+		return
+	}
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	if c == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	serveJSON(collectionMissingReport(c), w)
+}
+
+// Diagnostics of the last library scan, of all collections.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if adminCheck(w, r, "GET") {
+		return
+	}
+	reps := []HealthReport{}
+	for i := range config.Collections {
+		reps = append(reps, config.Collections[i].healthReport())
+	}
+	serveJSON(reps, w)
+}
+
+// Diagnostics of the last scan of one collection.
+func collectionHealthHandler(w http.ResponseWriter, r *http.Request) {
+	if adminCheck(w, r, "GET", "coll") {
+		return
+	}
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	if c == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	serveJSON(c.healthReport(), w)
+}
+
+// Items that are gone from disk, of all collections.
+func deletedHandler(w http.ResponseWriter, r *http.Request) {
+	if adminCheck(w, r, "GET") {
+		return
+	}
+	items, err := dbDeletedItems("")
+	if err != nil {
+		fmt.Printf("deletedHandler: %s\n", err)
+		http.Error(w, "500 Internal Server Error",
+			http.StatusInternalServerError)
+		return
+	}
+	serveJSON(items, w)
+}
+
+// Items of one collection that are gone from disk.
+func collectionDeletedHandler(w http.ResponseWriter, r *http.Request) {
+	if adminCheck(w, r, "GET", "coll") {
+		return
+	}
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	if c == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	items, err := dbDeletedItems(c.Name_)
+	if err != nil {
+		fmt.Printf("collectionDeletedHandler: %s\n", err)
+		http.Error(w, "500 Internal Server Error",
+			http.StatusInternalServerError)
+		return
+	}
+	serveJSON(items, w)
+}
+
+// Take a deleted item off the list, so that it is not purged.
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if adminCheck(w, r, "POST", "coll", "item") {
+		return
+	}
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	if c == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	err := dbRestoreItem(c, vars["item"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Printf("restoreHandler: %s\n", err)
+		http.Error(w, "500 Internal Server Error",
+			http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeNfoUpdate(w http.ResponseWriter, r *http.Request) (u *NfoUpdate) {
+	u = &NfoUpdate{}
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	err := d.Decode(u)
+	if err != nil {
+		http.Error(w, "400 Bad Request: " + err.Error(),
+			http.StatusBadRequest)
+		u = nil
+	}
+	return
+}
+
+func nfoUpdateError(w http.ResponseWriter, fn string, err error) {
+	fmt.Printf("updateNfo %s: %s\n", fn, err)
+	if err == ErrMultiEpisode || err == ErrReadOnly {
+		http.Error(w, "409 Conflict: " + err.Error(),
+			http.StatusConflict)
+		return
+	}
+	http.Error(w, "500 Internal Server Error",
+		http.StatusInternalServerError)
+}
+
+// Update the NFO file of a movie or show.
+func itemPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if adminCheck(w, r, "PATCH", "coll", "item") {
+		return
+	}
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	i := getItem(vars["coll"], vars["item"])
+	if c == nil || i == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	u := decodeNfoUpdate(w, r)
+	if u == nil {
+		return
+	}
+
+	root := "movie"
+	if i.Type == "show" {
+		root = "tvshow"
+	}
+	fn := itemNfoPath(c, i)
+	err := updateNfo(fn, root, u)
+	if err != nil {
+		nfoUpdateError(w, fn, err)
+		return
+	}
+
+	// the item is being served, update a copy and swap it in.
+	ni := *i
+	ni.NfoPath = fn
+	ni.NfoTime = 0
+	if itemCheckNfo(c, &ni) {
+		// the year was removed from the NFO file.
+		if u.Year != nil && *u.Year == 0 {
+			ni.Year = guessYear(&ni)
+		}
+		err = dbSaveItem(&ni)
+		if err != nil {
+			fmt.Printf("itemPatchHandler %s: %s\n", ni.Name, err)
+		}
+	}
+	c.replaceItem(i, &ni)
+
+	i2 := ni
+	i2.Nfo = loadNfo(i2.NfoPath)
+	i2.Seasons = nil
+	serveJSON(&i2, w)
+}
+
+// The year of an item that has none in its NFO file, as a scan
+// would guess it: from the name, or else from the first video.
+func guessYear(item *Item) (year int) {
+	_, year, _ = cleanTitle(item.Name)
+	if year == 0 && item.FirstVideo > 0 {
+		year = time.Unix(item.FirstVideo / 1000, 0).Year()
+	}
+	if year == 0 {
+		year = time.Now().Year()
+	}
+	return
+}
+
+// Update the NFO file of an episode.
+func episodePatchHandler(w http.ResponseWriter, r *http.Request) {
+	if adminCheck(w, r, "PATCH", "coll", "item", "season", "episode") {
+		return
+	}
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	i := getItem(vars["coll"], vars["item"])
+	if c == nil || i == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	sn, err1 := strconv.Atoi(vars["season"])
+	en, err2 := strconv.Atoi(vars["episode"])
+	var ep *Episode
+	if err1 == nil && err2 == nil {
+		ep = getEpisode(i, sn, en)
+	}
+	if ep == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	u := decodeNfoUpdate(w, r)
+	if u == nil {
+		return
+	}
+
+	fn := episodeNfoPath(c, i, ep)
+	err := updateNfo(fn, "episodedetails", u)
+	if err != nil {
+		nfoUpdateError(w, fn, err)
+		return
+	}
+
+	// the show is being served, update a copy and swap it in.
+	ni := i.copyShow()
+	ep = getEpisode(ni, sn, en)
+	ep.NfoPath = fn
+	if fi, err := os.Stat(fn); err == nil {
+		ep.NfoTime = TimeToUnixMS(fi.ModTime())
+	}
+	c.replaceItem(i, ni)
+	err = dbStoreOneItem(c, ni)
+	if err != nil {
+		fmt.Printf("episodePatchHandler %s: %s\n", ni.Name, err)
+	}
+
+	ep2 := *ep
+	ep2.Nfo = loadNfo(ep2.NfoPath)
+	serveJSON(&ep2, w)
+}
+
+// Show how a filename would be parsed, without scanning anything.
+// Query parameters: name (the filename) and, optionally, season (the
+// season number of the directory the file is in).
+func parseHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	var c *Collection
+	if coll, ok := vars["coll"]; ok {
+		c = getCollection(coll)
+		if c == nil {
+			http.Error(w, "404 Not Found", http.StatusNotFound)
+			return
+		}
+	}
+
+	r.ParseForm()
+	name := r.Form.Get("name")
+	if name == "" {
+		http.Error(w, "400 Bad Request: missing name",
+			http.StatusBadRequest)
+		return
+	}
+	seasonHint := -1
+	if s := r.Form.Get("season"); s != "" {
+		seasonHint = parseInt(s)
+	}
+
+	type parseResult struct {
+		Name		string		`json:"name"`
+		Title		string		`json:"title,omitempty"`
+		Year		int		`json:"year,omitempty"`
+		Release		*ReleaseInfo	`json:"release,omitempty"`
+		Episode		*EpisodeInfo	`json:"episode,omitempty"`
+	}
+	res := parseResult{ Name: name }
+
+	base := name
+	if s := isVideo.FindStringSubmatch(name); len(s) > 0 {
+		base = s[1]
+	}
+	res.Title, res.Year, res.Release = cleanTitle(base)
+	res.Episode = parseEpisodeInfo(c, base, seasonHint)
+
+	serveJSON(res, w)
+}
+
 func genresHandler(w http.ResponseWriter, r *http.Request) {
 	if preCheck(w, r, "coll") {
 		return
@@ -182,6 +605,17 @@ func genresHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isStored(c) {
+		gc, err := dbGenreCounts(c)
+		if err != nil {
+			http.Error(w, "500 Internal Server Error",
+				http.StatusInternalServerError)
+			return
+		}
+		serveJSON(gc, w)
+		return
+	}
+
 	gc := make(map[string]int)
 	for i := range c.Items {
 		for _, g := range c.Items[i].Genre {
@@ -199,3 +633,228 @@ func genresHandler(w http.ResponseWriter, r *http.Request) {
 	serveJSON(gc, w)
 }
 
+
+// Music library of a collection, or a 404 and nil.
+func getMusicLibrary(w http.ResponseWriter, r *http.Request) (lib *MusicLibrary) {
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	if c == nil || c.Type != "music" || c.music == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	lib = c.music
+	if lib.LastModified > 0 &&
+	   checkEtagObj(w, r, time.UnixMilli(lib.LastModified)) {
+		lib = nil
+		return
+	}
+	if r.Method == "HEAD" {
+		lib = nil
+	}
+	return
+}
+
+func artistsHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll") {
+		return
+	}
+	lib := getMusicLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	artists := make([]Artist, 0, len(lib.Artists))
+	for _, a := range lib.Artists {
+		artist := *a
+		artist.Albums = nil
+		artists = append(artists, artist)
+	}
+	serveJSON(artists, w)
+}
+
+func artistHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll", "artist") {
+		return
+	}
+	lib := getMusicLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	a := getArtist(lib, mux.Vars(r)["artist"])
+	if a == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	artist := *a
+	artist.Albums = []*Album{}
+	for _, al := range a.Albums {
+		album := *al
+		album.Tracks = nil
+		artist.Albums = append(artist.Albums, &album)
+	}
+	serveJSON(artist, w)
+}
+
+// All albums, or the albums of one artist with ?artist=id.
+func albumsHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll") {
+		return
+	}
+	if isPhotoCollection(r) {
+		photoAlbumsHandler(w, r)
+		return
+	}
+	lib := getMusicLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	r.ParseForm()
+	artist := r.Form.Get("artist")
+
+	albums := make([]Album, 0, len(lib.Albums))
+	for _, a := range lib.Albums {
+		if artist != "" && a.ArtistId != artist {
+			continue
+		}
+		album := *a
+		album.Tracks = nil
+		albums = append(albums, album)
+	}
+	serveJSON(albums, w)
+}
+
+func albumHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll", "album") {
+		return
+	}
+	if isPhotoCollection(r) {
+		photoAlbumHandler(w, r)
+		return
+	}
+	lib := getMusicLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	a := getAlbum(lib, mux.Vars(r)["album"])
+	if a == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	serveJSON(a, w)
+}
+
+// All tracks, optionally filtered with ?artist=id or ?album=id.
+func tracksHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll") {
+		return
+	}
+	lib := getMusicLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	r.ParseForm()
+	artist := r.Form.Get("artist")
+	album := r.Form.Get("album")
+
+	tracks := make([]*Track, 0, len(lib.Tracks))
+	for _, t := range lib.Tracks {
+		if (artist != "" && t.ArtistId != artist) ||
+		   (album != "" && t.AlbumId != album) {
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+	serveJSON(tracks, w)
+}
+
+func isPhotoCollection(r *http.Request) bool {
+	c := getCollection(mux.Vars(r)["coll"])
+	return c != nil && c.Type == "photos"
+}
+
+// Photo library of a collection, or a 404 and nil.
+func getPhotoLibrary(w http.ResponseWriter, r *http.Request) (lib *PhotoLibrary) {
+	vars := mux.Vars(r)
+	c := getCollection(vars["coll"])
+	if c == nil || c.Type != "photos" || c.photos == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	lib = c.photos
+	if lib.LastModified > 0 &&
+	   checkEtagObj(w, r, time.UnixMilli(lib.LastModified)) {
+		lib = nil
+		return
+	}
+	if r.Method == "HEAD" {
+		lib = nil
+	}
+	return
+}
+
+// All photo albums, or the albums in one album with ?parent=id.
+func photoAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	lib := getPhotoLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	r.ParseForm()
+	_, byParent := r.Form["parent"]
+	parent := r.Form.Get("parent")
+
+	albums := make([]PhotoAlbum, 0, len(lib.Albums))
+	for _, a := range lib.Albums {
+		if byParent && a.Parent != parent {
+			continue
+		}
+		album := *a
+		album.Photos = nil
+		albums = append(albums, album)
+	}
+	serveJSON(albums, w)
+}
+
+func photoAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	lib := getPhotoLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	a := getPhotoAlbum(lib, mux.Vars(r)["album"])
+	if a == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	serveJSON(a, w)
+}
+
+// Photos by date, grouped by ?group=day|month|year (default month).
+// ?from= and ?to= (YYYY-MM-DD) limit the range.
+func timelineHandler(w http.ResponseWriter, r *http.Request) {
+	if preCheck(w, r, "coll") {
+		return
+	}
+	lib := getPhotoLibrary(w, r)
+	if lib == nil {
+		return
+	}
+	r.ParseForm()
+	group := r.Form.Get("group")
+	switch group {
+	case "", "day", "month", "year":
+	default:
+		http.Error(w, "400 Bad Request: unknown group " + group,
+			http.StatusBadRequest)
+		return
+	}
+	from := r.Form.Get("from")
+	to := r.Form.Get("to")
+
+	photos := make([]*Photo, 0, len(lib.Photos))
+	for _, p := range lib.Photos {
+		date := p.taken.Format("2006-01-02")
+		if (from != "" && date < from) || (to != "" && date > to) {
+			continue
+		}
+		photos = append(photos, p)
+	}
+	serveJSON(photoTimeline(photos, group), w)
+}
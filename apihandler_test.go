@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestItemPatch(t *testing.T) {
+	testDb(t)
+	savedColls, savedToken := config.Collections, config.AdminToken
+	defer func() {
+		config.Collections, config.AdminToken = savedColls, savedToken
+	}()
+
+	dir := t.TempDir()
+	mdir := filepath.Join(dir, "Alien (1979)")
+	os.Mkdir(mdir, 0755)
+	os.WriteFile(filepath.Join(mdir, "Alien (1979).mp4"), []byte("video"), 0644)
+	os.WriteFile(filepath.Join(mdir, "Alien (1979).nfo"),
+		[]byte("<movie><title>Alien</title><year>1985</year></movie>"), 0644)
+
+	config.AdminToken = "secret"
+	config.Collections = []Collection{{
+		Name_: "test",
+		Type: "movies",
+		Directory: dir,
+	}}
+	c := &config.Collections[0]
+	c.Items = buildMovies(c, 0)
+	if len(c.Items) != 1 || c.Items[0].Year != 1985 {
+		t.Fatalf("scan: %d items", len(c.Items))
+	}
+	old := c.Items[0]
+
+	req := httptest.NewRequest("PATCH", "/api/collection/test/item/" + old.Id,
+		strings.NewReader(`{"title":"Alien NFO","year":0}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req = mux.SetURLVars(req, map[string]string{ "coll": "test", "item": old.Id })
+	w := httptest.NewRecorder()
+	itemPatchHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	item := c.Items[0]
+	if item == old {
+		t.Errorf("item updated in place")
+	}
+	if old.Title != "Alien" || old.Year != 1985 {
+		t.Errorf("old item changed: %q %d", old.Title, old.Year)
+	}
+	if item.Title != "Alien NFO" || item.Year != 1979 {
+		t.Errorf("new item: title %q year %d", item.Title, item.Year)
+	}
+}
+
+func TestEpisodePatch(t *testing.T) {
+	testDb(t)
+	savedColls, savedToken := config.Collections, config.AdminToken
+	defer func() {
+		config.Collections, config.AdminToken = savedColls, savedToken
+	}()
+
+	dir := t.TempDir()
+	sdir := filepath.Join(dir, "Show", "S1")
+	os.MkdirAll(sdir, 0755)
+	os.WriteFile(filepath.Join(dir, "Show", "tvshow.nfo"),
+		[]byte("<tvshow><title>Show</title></tvshow>"), 0644)
+	os.WriteFile(filepath.Join(sdir, "Show.S01E01.mp4"), []byte("video"), 0644)
+
+	config.AdminToken = "secret"
+	config.Collections = []Collection{{
+		Name_: "test",
+		Type: "shows",
+		Directory: dir,
+	}}
+	c := &config.Collections[0]
+	c.Items = buildShows(c, 0)
+	if len(c.Items) != 1 {
+		t.Fatalf("scan: %d items", len(c.Items))
+	}
+	old := c.Items[0]
+
+	req := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"title":"One"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req = mux.SetURLVars(req, map[string]string{
+		"coll": "test", "item": old.Id, "season": "1", "episode": "1",
+	})
+	w := httptest.NewRecorder()
+	episodePatchHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	if c.Items[0] == old {
+		t.Fatalf("show updated in place")
+	}
+	if ep := getEpisode(old, 1, 1); ep.NfoPath != "" {
+		t.Errorf("old episode changed: %q", ep.NfoPath)
+	}
+	ep := getEpisode(c.Items[0], 1, 1)
+	if ep.NfoPath == "" || ep.NfoTime == 0 {
+		t.Errorf("new episode: nfo %q time %d", ep.NfoPath, ep.NfoTime)
+	}
+	var nfoTime int64
+	dbHandle.Get(&nfoTime, "SELECT nfotime FROM episodes WHERE id = ?", ep.Id)
+	if nfoTime != ep.NfoTime {
+		t.Errorf("stored nfotime %d, want %d", nfoTime, ep.NfoTime)
+	}
+}
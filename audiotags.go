@@ -0,0 +1,532 @@
+// Read the tags from audio files: ID3 (mp3), Vorbis comments
+// (flac, ogg, opus) and MP4 `ilst' atoms (m4a).
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+type AudioTags struct {
+	Title		string
+	Artist		string
+	AlbumArtist	string
+	Album		string
+	Genre		string
+	Year		int
+	TrackNo		int
+	DiscNo		int
+	Duration	float64
+}
+
+// max. size of a tag we read into memory. Big tags usually are
+// big because of embedded pictures, which come after the text.
+const maxTagSize = 1 << 20
+
+// The genres from ID3v1. Also used in ID3v2 and MP4.
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk",
+	"Grunge", "Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other",
+	"Pop", "R&B", "Rap", "Reggae", "Rock", "Techno", "Industrial",
+	"Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack",
+	"Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion",
+	"Trance", "Classical", "Instrumental", "Acid", "House", "Game",
+	"Sound Clip", "Gospel", "Noise", "AlternRock", "Bass", "Soul", "Punk",
+	"Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic",
+	"Pop-Folk", "Eurodance", "Dream", "Southern Rock", "Comedy", "Cult",
+	"Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave",
+	"Showtunes", "Trailer", "Lo-Fi", "Tribal", "Acid Punk", "Acid Jazz",
+	"Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+}
+
+func id3Genre(n int) string {
+	if n >= 0 && n < len(id3Genres) {
+		return id3Genres[n]
+	}
+	return ""
+}
+
+// Read the tags from an audio file. Returns nil if the
+// file has no tags we understand.
+func readAudioTags(fn string) (tags *AudioTags) {
+	f, err := vfsOpen(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	size := fi.Size()
+
+	var magic [8]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil {
+		return
+	}
+	switch {
+	case string(magic[0:4]) == "fLaC":
+		tags = readFlacTags(f, 0, size)
+	case string(magic[0:4]) == "OggS":
+		tags = readOggTags(f, size)
+	case string(magic[4:8]) == "ftyp":
+		tags = readMp4Tags(f, size)
+	case string(magic[0:3]) == "ID3":
+		tags, size = readID3v2(f)
+		if tags == nil {
+			tags = readID3v1(f, fi.Size())
+		}
+		// flac with an id3 tag in front. Yes, those exist.
+		if f.ReadAt(magic[:4], size); string(magic[:4]) == "fLaC" {
+			if t := readFlacTags(f, size, fi.Size()); t != nil {
+				tags = t
+			}
+		}
+	default:
+		tags = readID3v1(f, size)
+	}
+	return
+}
+
+// "3/12" -> 3
+func tagNumber(s string) (n int) {
+	if i := strings.Index(s, "/"); i >= 0 {
+		s = s[:i]
+	}
+	n, _ = strconv.Atoi(strings.TrimSpace(s))
+	return
+}
+
+// "2011-05-03" -> 2011
+func tagYear(s string) (n int) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 4 {
+		n, _ = strconv.Atoi(s[:4])
+	}
+	return
+}
+
+// ID3v2 genres can be "(17)", "17", "(17)Rock" or just "Rock".
+func tagGenre(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") {
+		if i := strings.Index(s, ")"); i > 0 {
+			if s[i+1:] != "" {
+				return s[i+1:]
+			}
+			s = s[1:i]
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return id3Genre(n)
+	}
+	return s
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i := range b {
+		r[i] = rune(b[i])
+	}
+	return string(r)
+}
+
+// trim at the first NUL. ID3v2.4 uses NUL to separate multiple
+// values, we only use the first one.
+func cString(s string) string {
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// ID3v2 sizes are "syncsafe": 7 bits per byte.
+func syncsafe(b []byte) (n int) {
+	for _, c := range b {
+		n = n << 7 | int(c & 0x7f)
+	}
+	return
+}
+
+// undo "unsynchronisation": 0xff 0x00 -> 0xff
+func unsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// Decode an ID3v2 text frame.
+func id3Text(b []byte) string {
+	if len(b) < 1 {
+		return ""
+	}
+	enc, b := b[0], b[1:]
+	switch enc {
+	case 0:
+		return cString(latin1(b))
+	case 1, 2:
+		bigEndian := enc == 2
+		if len(b) >= 2 {
+			if b[0] == 0xff && b[1] == 0xfe {
+				bigEndian, b = false, b[2:]
+			} else if b[0] == 0xfe && b[1] == 0xff {
+				bigEndian, b = true, b[2:]
+			}
+		}
+		u := make([]uint16, len(b) / 2)
+		for i := range u {
+			if bigEndian {
+				u[i] = binary.BigEndian.Uint16(b[2*i:])
+			} else {
+				u[i] = binary.LittleEndian.Uint16(b[2*i:])
+			}
+		}
+		return cString(string(utf16.Decode(u)))
+	}
+	return cString(string(b))
+}
+
+// Read the ID3v2 tag at the start of the file. Also returns the
+// size of the tag, which is where the audio data starts.
+func readID3v2(r io.ReaderAt) (tags *AudioTags, end int64) {
+	var hdr [10]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil || string(hdr[:3]) != "ID3" {
+		return
+	}
+	version, flags := hdr[3], hdr[5]
+	size := syncsafe(hdr[6:10])
+	end = int64(size) + 10
+	if flags & 0x10 != 0 {
+		end += 10
+	}
+	if version < 2 || version > 4 {
+		return
+	}
+
+	if size > maxTagSize {
+		size = maxTagSize
+	}
+	buf := make([]byte, size)
+	n, _ := r.ReadAt(buf, 10)
+	buf = buf[:n]
+	if flags & 0x80 != 0 && version < 4 {
+		buf = unsync(buf)
+	}
+	if flags & 0x40 != 0 && len(buf) >= 4 {
+		// skip extended header.
+		var l int
+		if version == 3 {
+			l = int(binary.BigEndian.Uint32(buf[0:4])) + 4
+		} else {
+			l = syncsafe(buf[0:4])
+		}
+		if l > len(buf) {
+			return
+		}
+		buf = buf[l:]
+	}
+
+	tags = &AudioTags{}
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(buf) >= hdrLen && buf[0] != 0 {
+		id := string(buf[:idLen])
+		var l int
+		switch version {
+		case 2:
+			l = int(buf[3]) << 16 | int(buf[4]) << 8 | int(buf[5])
+		case 3:
+			l = int(binary.BigEndian.Uint32(buf[4:8]))
+		case 4:
+			l = syncsafe(buf[4:8])
+		}
+		if l < 0 || hdrLen + l > len(buf) {
+			break
+		}
+		data := buf[hdrLen:hdrLen + l]
+		if version == 4 && buf[9] & 0x02 != 0 {
+			data = unsync(data)
+		}
+		buf = buf[hdrLen + l:]
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = id3Text(data)
+		case "TPE1", "TP1":
+			tags.Artist = id3Text(data)
+		case "TPE2", "TP2":
+			tags.AlbumArtist = id3Text(data)
+		case "TALB", "TAL":
+			tags.Album = id3Text(data)
+		case "TRCK", "TRK":
+			tags.TrackNo = tagNumber(id3Text(data))
+		case "TPOS", "TPA":
+			tags.DiscNo = tagNumber(id3Text(data))
+		case "TYER", "TYE", "TDRC":
+			tags.Year = tagYear(id3Text(data))
+		case "TCON", "TCO":
+			tags.Genre = tagGenre(id3Text(data))
+		case "TLEN", "TLE":
+			if ms := tagNumber(id3Text(data)); ms > 0 {
+				tags.Duration = float64(ms) / 1000
+			}
+		}
+	}
+	return
+}
+
+// The old 128 byte tag at the end of the file.
+func readID3v1(r io.ReaderAt, size int64) (tags *AudioTags) {
+	if size < 128 {
+		return
+	}
+	var b [128]byte
+	if _, err := r.ReadAt(b[:], size - 128); err != nil || string(b[:3]) != "TAG" {
+		return
+	}
+	tags = &AudioTags{
+		Title: cString(latin1(b[3:33])),
+		Artist: cString(latin1(b[33:63])),
+		Album: cString(latin1(b[63:93])),
+		Year: tagYear(string(b[93:97])),
+		Genre: id3Genre(int(b[127])),
+	}
+	// ID3v1.1 has the track number in the last byte of the comment.
+	if b[125] == 0 && b[126] != 0 {
+		tags.TrackNo = int(b[126])
+	}
+	return
+}
+
+// Vorbis comments are used by FLAC, Ogg Vorbis and Opus.
+func parseVorbisComment(b []byte, tags *AudioTags) {
+	if len(b) < 8 {
+		return
+	}
+	l := int(binary.LittleEndian.Uint32(b))
+	if 4 + l + 4 > len(b) {
+		return
+	}
+	b = b[4 + l:]
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count && len(b) >= 4; i++ {
+		l := int(binary.LittleEndian.Uint32(b))
+		if l < 0 || 4 + l > len(b) {
+			return
+		}
+		kv := string(b[4:4 + l])
+		b = b[4 + l:]
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			continue
+		}
+		val := strings.TrimSpace(kv[eq+1:])
+		switch strings.ToUpper(kv[:eq]) {
+		case "TITLE":
+			tags.Title = val
+		case "ARTIST":
+			if tags.Artist == "" {
+				tags.Artist = val
+			}
+		case "ALBUMARTIST", "ALBUM ARTIST":
+			tags.AlbumArtist = val
+		case "ALBUM":
+			tags.Album = val
+		case "TRACKNUMBER":
+			tags.TrackNo = tagNumber(val)
+		case "DISCNUMBER":
+			tags.DiscNo = tagNumber(val)
+		case "DATE", "YEAR":
+			tags.Year = tagYear(val)
+		case "GENRE":
+			if tags.Genre == "" {
+				tags.Genre = val
+			}
+		}
+	}
+}
+
+// FLAC metadata blocks. STREAMINFO has the length of the
+// stream, VORBIS_COMMENT the tags.
+func readFlacTags(r io.ReaderAt, start int64, size int64) (tags *AudioTags) {
+	tags = &AudioTags{}
+	var hdr [4]byte
+	for pos := start + 4; pos + 4 <= size; {
+		if _, err := r.ReadAt(hdr[:], pos); err != nil {
+			return
+		}
+		last := hdr[0] & 0x80 != 0
+		typ := hdr[0] & 0x7f
+		l := int64(hdr[1]) << 16 | int64(hdr[2]) << 8 | int64(hdr[3])
+		pos += 4
+		if typ == 0 || typ == 4 {
+			if l > maxTagSize {
+				return
+			}
+			b := make([]byte, l)
+			if _, err := r.ReadAt(b, pos); err != nil {
+				return
+			}
+			if typ == 0 && len(b) >= 18 {
+				rate := int64(b[10]) << 12 | int64(b[11]) << 4 | int64(b[12]) >> 4
+				samples := int64(b[13] & 0x0f) << 32 |
+					int64(binary.BigEndian.Uint32(b[14:18]))
+				if rate > 0 {
+					tags.Duration = float64(samples) / float64(rate)
+				}
+			}
+			if typ == 4 {
+				parseVorbisComment(b, tags)
+			}
+		}
+		pos += l
+		if last {
+			break
+		}
+	}
+	return
+}
+
+// Return the first `n' packets of an Ogg stream.
+func oggPackets(r io.ReaderAt, n int) (packets [][]byte) {
+	var cur []byte
+	var hdr [27]byte
+	var segs [255]byte
+	for pos := int64(0); len(packets) < n; {
+		if _, err := r.ReadAt(hdr[:], pos); err != nil || string(hdr[:4]) != "OggS" {
+			return
+		}
+		nsegs := int(hdr[26])
+		if _, err := r.ReadAt(segs[:nsegs], pos + 27); err != nil {
+			return
+		}
+		pos += 27 + int64(nsegs)
+		for _, l := range segs[:nsegs] {
+			b := make([]byte, l)
+			if _, err := r.ReadAt(b, pos); err != nil {
+				return
+			}
+			pos += int64(l)
+			cur = append(cur, b...)
+			if len(cur) > maxTagSize {
+				return
+			}
+			if l < 255 {
+				packets = append(packets, cur)
+				cur = nil
+				if len(packets) == n {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// Granule position of the last page, which is the number of samples.
+func oggLastGranule(r io.ReaderAt, size int64) (granule int64) {
+	n := int64(65536)
+	if n > size {
+		n = size
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, size - n); err != nil && err != io.EOF {
+		return
+	}
+	i := bytes.LastIndex(b, []byte("OggS"))
+	if i < 0 || i + 14 > len(b) {
+		return
+	}
+	granule = int64(binary.LittleEndian.Uint64(b[i+6:i+14]))
+	return
+}
+
+func readOggTags(r io.ReaderAt, size int64) (tags *AudioTags) {
+	p := oggPackets(r, 2)
+	if len(p) < 2 {
+		return
+	}
+	tags = &AudioTags{}
+	var rate, preskip int64
+	switch {
+	case bytes.HasPrefix(p[1], []byte("\x03vorbis")):
+		parseVorbisComment(p[1][7:], tags)
+		if len(p[0]) >= 16 {
+			rate = int64(binary.LittleEndian.Uint32(p[0][12:16]))
+		}
+	case bytes.HasPrefix(p[1], []byte("OpusTags")):
+		parseVorbisComment(p[1][8:], tags)
+		// opus always runs at 48 KHz.
+		rate = 48000
+		if len(p[0]) >= 12 {
+			preskip = int64(binary.LittleEndian.Uint16(p[0][10:12]))
+		}
+	default:
+		return nil
+	}
+	if g := oggLastGranule(r, size); rate > 0 && g > preskip {
+		tags.Duration = float64(g - preskip) / float64(rate)
+	}
+	return
+}
+
+// iTunes style tags in moov/udta/meta/ilst.
+func readMp4Tags(r io.ReaderAt, size int64) (tags *AudioTags) {
+	tags = &AudioTags{}
+	if _, d, ok := mp4MovieHeader(r, size); ok {
+		tags.Duration = d
+	}
+	ilst, ok := mp4Find(r, size, "moov", "udta", "meta", "ilst")
+	if !ok {
+		return
+	}
+	for _, item := range mp4Boxes(r, ilst.offset, ilst.offset + ilst.size) {
+		var data []byte
+		for _, b := range mp4Boxes(r, item.offset, item.offset + item.size) {
+			if b.typ == "data" {
+				data, _ = mp4Read(r, b, 65536)
+				break
+			}
+		}
+		// 4 bytes type, 4 bytes locale.
+		if len(data) < 8 {
+			continue
+		}
+		data = data[8:]
+		text := strings.TrimSpace(string(data))
+		switch item.typ {
+		case "\xa9nam":
+			tags.Title = text
+		case "\xa9ART":
+			tags.Artist = text
+		case "aART":
+			tags.AlbumArtist = text
+		case "\xa9alb":
+			tags.Album = text
+		case "\xa9day":
+			tags.Year = tagYear(text)
+		case "\xa9gen":
+			tags.Genre = text
+		case "gnre":
+			if len(data) >= 2 {
+				tags.Genre = id3Genre(int(binary.BigEndian.Uint16(data)) - 1)
+			}
+		case "trkn":
+			if len(data) >= 4 {
+				tags.TrackNo = int(binary.BigEndian.Uint16(data[2:4]))
+			}
+		case "disk":
+			if len(data) >= 4 {
+				tags.DiscNo = int(binary.BigEndian.Uint16(data[2:4]))
+			}
+		}
+	}
+	return
+}
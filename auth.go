@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Check if the request carries the admin token, either as
+// "Authorization: Bearer <token>" or as the basic-auth password.
+func isAdmin(r *http.Request) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+	token := ""
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(auth[7:])
+	} else if _, pw, ok := r.BasicAuth(); ok {
+		token = pw
+	}
+	t1 := []byte(token)
+	t2 := []byte(config.AdminToken)
+	return subtle.ConstantTimeCompare(t1, t2) == 1
+}
+
+// Like preCheck, but for requests that need the admin token.
+// Apart from OPTIONS, only `method' is allowed (and HEAD if
+// `method' is GET).
+func adminCheck(w http.ResponseWriter, r *http.Request, method string, keys ...string) (done bool) {
+	vars := mux.Vars(r)
+	for _, k := range keys {
+		if _, ok := vars[k]; !ok {
+			http.Error(w, "500 Internal Server Error",
+				http.StatusInternalServerError)
+			done = true
+			return
+		}
+	}
+	setheaders(w.Header())
+	if r.Method == "OPTIONS" {
+		done = true
+		return
+	}
+	if r.Method != method && (method != "GET" || r.Method != "HEAD") {
+		http.Error(w, "405 Method Not Allowed",
+			http.StatusMethodNotAllowed)
+		done = true
+		return
+	}
+	if !isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="notflix"`)
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		done = true
+	}
+	return
+}
@@ -0,0 +1,165 @@
+// Clean up scene-style release names.
+// Example: The.Matrix.1999.1080p.BluRay.x264-GRP -> "The Matrix", 1999,
+// and release info 1080p / BluRay / x264 / GRP.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+type ReleaseInfo struct {
+	Resolution	string		`json:"resolution,omitempty"`
+	Source		string		`json:"source,omitempty"`
+	Codec		string		`json:"codec,omitempty"`
+	Group		string		`json:"group,omitempty"`
+}
+
+var releaseResolution = map[string]string{
+	"480p":		"480p",
+	"576p":		"576p",
+	"720p":		"720p",
+	"1080i":	"1080i",
+	"1080p":	"1080p",
+	"2160p":	"2160p",
+	"4k":		"2160p",
+	"uhd":		"2160p",
+}
+
+var releaseSource = map[string]string{
+	"bluray":	"BluRay",
+	"blu-ray":	"BluRay",
+	"bdrip":	"BluRay",
+	"brrip":	"BluRay",
+	"bdremux":	"BluRay",
+	"remux":	"BluRay",
+	"web-dl":	"WEB-DL",
+	"webdl":	"WEB-DL",
+	"webrip":	"WEBRip",
+	"web":		"WEB",
+	"hdtv":		"HDTV",
+	"pdtv":		"PDTV",
+	"dvdrip":	"DVD",
+	"dvd":		"DVD",
+	"dvdr":		"DVD",
+	"hdrip":	"HDRip",
+}
+
+var releaseCodec = map[string]string{
+	"x264":		"x264",
+	"h264":		"H.264",
+	"avc":		"H.264",
+	"x265":		"x265",
+	"h265":		"H.265",
+	"hevc":		"H.265",
+	"xvid":		"XviD",
+	"divx":		"DivX",
+	"av1":		"AV1",
+	"vp9":		"VP9",
+}
+
+// Words that only appear in the release part of a name.
+var releaseJunk = map[string]bool{
+	"proper": true, "repack": true, "rerip": true, "real": true,
+	"extended": true, "unrated": true, "remastered": true,
+	"directors": true, "dc": true, "uncut": true, "internal": true,
+	"limited": true, "multi": true, "dubbed": true, "subbed": true,
+	"hdr": true, "hdr10": true, "dv": true, "10bit": true, "8bit": true,
+	"dts": true, "dts-hd": true, "truehd": true, "atmos": true,
+	"ac3": true, "aac": true, "eac3": true, "flac": true, "dd": true,
+	"ddp": true,
+	"amzn": true, "nf": true, "dsnp": true, "hmax": true, "atvp": true,
+}
+
+var releaseCodecFix = regexp.MustCompile(`(?i)\bh\.(26[45])\b`)
+var releaseAudioFix = regexp.MustCompile(`(?i)\b(ddp?|e?ac3|aac|dts)[257]\.[01]\b`)
+var releaseGroup = regexp.MustCompile(`-([A-Za-z0-9]+)$`)
+var releaseBracket = regexp.MustCompile(`^\[([^\]]+)\][ ._]*`)
+var releaseSplit = regexp.MustCompile(`[ ._]+`)
+var releaseYear = regexp.MustCompile(`^[(\[]?((19|20)[0-9]{2})[)\]]?$`)
+
+func isReleaseTag(word string, info *ReleaseInfo) bool {
+	w := strings.ToLower(strings.Trim(word, "()[]"))
+	if r, ok := releaseResolution[w]; ok {
+		info.Resolution = r
+		return true
+	}
+	if s, ok := releaseSource[w]; ok {
+		info.Source = s
+		return true
+	}
+	if c, ok := releaseCodec[w]; ok {
+		info.Codec = c
+		return true
+	}
+	return releaseJunk[w]
+}
+
+// Split a release name into a title, a year and release info.
+// `info' is nil if nothing release-like was found.
+func cleanTitle(name string) (title string, year int, info *ReleaseInfo) {
+	var ri ReleaseInfo
+
+	// `H.264' and `DD5.1' would get split up by the word splitter.
+	name = releaseCodecFix.ReplaceAllString(name, "h$1")
+	name = releaseAudioFix.ReplaceAllString(name, "$1")
+
+	// anime-style [Group] prefix.
+	if s := releaseBracket.FindStringSubmatch(name); len(s) > 0 {
+		ri.Group = s[1]
+		name = name[len(s[0]):]
+	}
+
+	// -GROUP suffix. Only a group if there are other release tags,
+	// otherwise it's probably a hyphenated title.
+	group := ""
+	if s := releaseGroup.FindStringSubmatchIndex(name); len(s) > 0 {
+		group = name[s[2]:s[3]]
+		name = name[:s[0]]
+	}
+
+	words := releaseSplit.Split(strings.TrimSpace(name), -1)
+
+	// find the first release tag. the title and the year come before it.
+	end := len(words)
+	for i, w := range words {
+		if i > 0 && isReleaseTag(w, &ri) {
+			end = i
+			for _, w2 := range words[i+1:] {
+				isReleaseTag(w2, &ri)
+			}
+			break
+		}
+	}
+	if end == len(words) && group != "" {
+		// no release tags, the hyphen belongs to the title.
+		words[len(words)-1] += "-" + group
+		group = ""
+	}
+	if group != "" {
+		ri.Group = group
+	}
+
+	// the last year before the release tags is the year, the
+	// title is everything before that. The title itself can
+	// look like a year too (`1917'), so skip the first word.
+	for i := end - 1; i > 0; i-- {
+		s := releaseYear.FindStringSubmatch(words[i])
+		if len(s) > 0 && parseInt(s[1]) <= time.Now().Year() + 1 {
+			year = parseInt(s[1])
+			end = i
+			break
+		}
+	}
+	title = strings.TrimSpace(strings.Join(words[:end], " "))
+	if title == "" {
+		title = name
+	}
+
+	if ri != (ReleaseInfo{}) {
+		info = &ri
+	}
+	return
+}
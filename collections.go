@@ -14,6 +14,20 @@ type Collection struct {
 	Directory	string		`json:"-"`
 	BaseUrl		string		`json:"-"`
 	HlsServer	string		`json:"-"`
+	EpisodePattern	[]string	`json:"-" cc:"episode-pattern"`
+	Ignore		[]string	`json:"-"`
+	Layout		string		`json:"-"`
+	StrmProxy	bool		`json:"-" cc:"strm-proxy"`
+	S3		*S3Config	`json:"-"`
+	Remote		*RemoteConfig	`json:"-"`
+
+	episodePatterns	[]*episodePattern
+	scanDiags	*scanDiags
+	remote		*remoteState
+	music		*MusicLibrary
+	photos		*PhotoLibrary
+	// scanned for a report, nothing is written to the database.
+	readOnly	bool
 }
 
 // An 'item' can be a movie, a tv-show, a folder, etc.
@@ -21,6 +35,7 @@ type Item struct {
 	// generic
 	Id		string		`json:"id"`
 	Name		string		`json:"name"`
+	Title		string		`json:"title,omitempty"`
 	Path		string		`json:"path"`
 	BaseUrl		string		`json:"baseurl"`
 	Type		string		`json:"type"`
@@ -34,19 +49,32 @@ type Item struct {
 	Fanart		string		`json:"fanart,omitempty"`
 	Folder		string		`json:"folder,omitempty"`
 	Poster		string		`json:"poster,omitempty"`
+	Art		*Art		`json:"art,omitempty"`
 	Rating		float32		`json:"rating,omitempty"`
 	Votes		int		`json:"votes,omitempty"`
 	Genre		[]string	`json:"genre,omitempty"`
-	Genrestring	string		`json:"-"`
 	Year		int		`json:"year,omitempty"`
+	Release		*ReleaseInfo	`json:"release,omitempty"`
+	Releasestring	string		`json:"-"`
+	UniqueIds	map[string]string	`json:"uniqueids,omitempty"`
 
 	// movie
 	Video			string		`json:"video,omitempty"`
+	Stream			string		`json:"stream,omitempty"`
+	Disc			*DiscInfo	`json:"disc,omitempty"`
 	Thumb			string		`json:"thumb,omitempty"`
 	SrtSubs			[]Subs		`json:"srtsubs,omitempty"`
 	VttSubs			[]Subs		`json:"vttsubs,omitempty"`
 
+	// home video
+	Recorded	string		`json:"recorded,omitempty"`
+
+	// folder
+	Parent		string		`json:"parent,omitempty"`
+	Children	[]*Item		`json:"children,omitempty"`
+
 	// show
+	EpisodeOrder	string		`json:"episodeorder,omitempty"`
 	SeasonAllBanner	string		`json:"seasonAllBanner,omitempty"`
 	SeasonAllFanart	string		`json:"seasonAllFanart,omitempty"`
 	SeasonAllPoster	string		`json:"seasonAllPoster,omitempty"`
@@ -54,17 +82,23 @@ type Item struct {
 }
 
 type Season struct {
+	Id		string		`json:"id"`
 	SeasonNo	int		`json:"seasonno"`
 	Banner		string		`json:"banner,omitempty"`
 	Fanart		string		`json:"fanart,omitempty"`
 	Poster		string		`json:"poster,omitempty"`
+	Art		*Art		`json:"art,omitempty"`
 	Episodes	[]Episode	`json:"episodes,omitempty"`
 }
 
 type Episode struct {
+	Id		string		`json:"id"`
 	Name		string		`json:"name"`
 	SeasonNo	int		`json:"seasonno"`
 	EpisodeNo	int		`json:"episodeno"`
+	LastEpisodeNo	int		`json:"lastepisodeno,omitempty"`
+	AbsoluteNo	int		`json:"absoluteno,omitempty"`
+	AirDate		string		`json:"airdate,omitempty"`
 	Double		bool		`json:"double,omitempty"`
 	SortName	string		`json:"sortName,omitempty"`
 	BaseName	string		`json:"-"`
@@ -73,11 +107,28 @@ type Episode struct {
 	VideoTS		int64		`json:"-"`
 	Nfo		*Nfo		`json:"nfo,omitempty"`
 	Video		string		`json:"video"`
+	Stream		string		`json:"stream,omitempty"`
 	Thumb		string		`json:"thumb,omitempty"`
 	SrtSubs		[]Subs		`json:"srtsubs,omitempty"`
 	VttSubs		[]Subs		`json:"vttsubs,omitempty"`
 }
 
+// All the standard `Kodi' artwork types. Paths are relative to the item.
+type Art struct {
+	Banner		string		`json:"banner,omitempty"`
+	Characterart	string		`json:"characterart,omitempty"`
+	Clearart	string		`json:"clearart,omitempty"`
+	Clearlogo	string		`json:"clearlogo,omitempty"`
+	Discart		string		`json:"discart,omitempty"`
+	Fanart		string		`json:"fanart,omitempty"`
+	Folder		string		`json:"folder,omitempty"`
+	Keyart		string		`json:"keyart,omitempty"`
+	Landscape	string		`json:"landscape,omitempty"`
+	Poster		string		`json:"poster,omitempty"`
+	Thumb		string		`json:"thumb,omitempty"`
+	Extrafanart	[]string	`json:"extrafanart,omitempty"`
+}
+
 type Subs struct {
 	Lang		string		`json:"lang"`
 	Path		string		`json:"path"`
@@ -123,23 +174,73 @@ func (p PathString) String() string {
 }
 
 func updateCollections(pace int) {
-	id := 1
 	for i := range config.Collections {
-		c := &(config.Collections[i])
-		c.SourceId = id
-		c.BaseUrl = fmt.Sprintf("/data/%d", id)
-		switch c.Type {
-		case "movies":
-			buildMovies(c, pace)
-		case "shows":
-			buildShows(c, pace)
-		}
-		id++
+		updateCollection(&(config.Collections[i]), i + 1, pace)
+	}
+}
+
+func updateCollection(c *Collection, id int, pace int) {
+	c.SourceId = id
+	c.BaseUrl = fmt.Sprintf("/data/%d", id)
+	c.diagBegin()
+	if err := c.mount(); err != nil {
+		c.diag(diagError, "", c.Directory, "cannot open collection: %s", err)
+		c.diagEnd()
+		return
+	}
+	switch c.Type {
+	case "movies":
+		buildMovies(c, pace)
+	case "shows", "anime":
+		buildShows(c, pace)
+	case "folder":
+		buildFolders(c, pace)
+	case "music":
+		buildMusic(c, pace)
+	case "photos":
+		buildPhotos(c, pace)
+	case "homevideos":
+		buildHomeVideos(c, pace)
+	case "remote":
+		buildRemote(c, pace)
+	}
+	c.diagEnd()
+	// items that were not seen would be marked as deleted.
+	if c.scanFailed() || c.readOnly {
+		return
+	}
+	if err := dbSaveSnapshot(c); err != nil {
+		fmt.Printf("%s: cannot save snapshot: %s\n", c.Name_, err)
 	}
 }
 
+// Scan a collection for a report, without writing to the database.
+func scanForReport(c *Collection, id int) {
+	c.readOnly = true
+	updateCollection(c, id, 0)
+}
+
+// Collections with a snapshot in the database are served from that
+// right away, the rest is scanned now. Archives and buckets are
+// mounted first, or their files cannot be served.
 func initCollections() {
-	updateCollections(0)
+	for i := range config.Collections {
+		c := &(config.Collections[i])
+		c.SourceId = i + 1
+		c.BaseUrl = fmt.Sprintf("/data/%d", c.SourceId)
+		if err := c.mount(); err != nil {
+			// the scan reports the error.
+			updateCollection(c, i + 1, 0)
+			continue
+		}
+		ok, err := dbLoadSnapshot(c)
+		if err != nil {
+			fmt.Printf("%s: cannot load snapshot: %s\n", c.Name_, err)
+		}
+		if !ok {
+			updateCollection(c, i + 1, 0)
+		}
+	}
 }
 
 func getCollection(collName string) (c *Collection) {
@@ -157,13 +258,56 @@ func getCollection(collName string) (c *Collection) {
 	return
 }
 
+// Replace an item, in the list and as a child of a folder, by an
+// updated copy.
+func (c *Collection) replaceItem(old *Item, item *Item) {
+	for i, n := range c.Items {
+		if n == old {
+			c.Items[i] = item
+		}
+		for j, ch := range n.Children {
+			if ch == old {
+				n.Children[j] = item
+			}
+		}
+	}
+}
+
+// A copy of a show with its own seasons and episodes.
+func (show *Item) copyShow() (ni *Item) {
+	c := *show
+	ni = &c
+	ni.Seasons = make([]Season, len(show.Seasons))
+	for si := range show.Seasons {
+		ni.Seasons[si] = show.Seasons[si]
+		ni.Seasons[si].Episodes = append([]Episode(nil),
+					show.Seasons[si].Episodes...)
+	}
+	return
+}
+
 func getItem(collName string, itemName string) (i *Item) {
 	c := getCollection(collName)
 	if c == nil {
 		return
 	}
 	for _, n := range c.Items {
-		if n.Name == itemName || n.Id == itemName {
+		if n.Id == itemName {
+			i = n
+			return
+		}
+	}
+	// names in folder collections are not unique, paths are.
+	if c.Type == "folder" {
+		for _, n := range c.Items {
+			if unescapePath(n.Path) == itemName {
+				i = n
+				return
+			}
+		}
+	}
+	for _, n := range c.Items {
+		if n.Name == itemName {
 			i = n
 			return
 		}
@@ -171,6 +315,78 @@ func getItem(collName string, itemName string) (i *Item) {
 	return
 }
 
+func getEpisode(item *Item, seasonNo int, episodeNo int) (ep *Episode) {
+	for si := range item.Seasons {
+		s := &(item.Seasons[si])
+		if s.SeasonNo != seasonNo {
+			continue
+		}
+		for ei := range s.Episodes {
+			if s.Episodes[ei].EpisodeNo == episodeNo {
+				ep = &(s.Episodes[ei])
+				return
+			}
+		}
+	}
+	return
+}
+
+// Find a season by number, or by id.
+func getSeasonByKey(item *Item, key string) (s *Season) {
+	n, err := strconv.Atoi(key)
+	for si := range item.Seasons {
+		s = &(item.Seasons[si])
+		if (err == nil && s.SeasonNo == n) || s.Id == key {
+			return
+		}
+	}
+	s = nil
+	return
+}
+
+func getEpisodeById(item *Item, id string) (ep *Episode) {
+	for si := range item.Seasons {
+		s := &(item.Seasons[si])
+		for ei := range s.Episodes {
+			if s.Episodes[ei].Id == id {
+				ep = &(s.Episodes[ei])
+				return
+			}
+		}
+	}
+	return
+}
+
+// Find an episode by its air date (YYYY-MM-DD).
+func getEpisodeByDate(item *Item, date string) (ep *Episode) {
+	for si := range item.Seasons {
+		s := &(item.Seasons[si])
+		for ei := range s.Episodes {
+			if s.Episodes[ei].AirDate == date {
+				ep = &(s.Episodes[ei])
+				return
+			}
+		}
+	}
+	return
+}
+
+// Last time the item or any of its NFO files changed, in milliseconds.
+func (item *Item) modTime() (ts int64) {
+	ts = item.LastVideo
+	if item.NfoTime > ts {
+		ts = item.NfoTime
+	}
+	for _, s := range item.Seasons {
+		for _, ep := range s.Episodes {
+			if ep.NfoTime > ts {
+				ts = ep.NfoTime
+			}
+		}
+	}
+	return
+}
+
 func getHlsServer(source string) (h string) {
 	id, err := strconv.ParseInt(source, 10, 64)
 	if err != nil {
@@ -185,6 +401,20 @@ func getHlsServer(source string) (h string) {
 	return
 }
 
+func getStrmProxy(source string) (p bool) {
+	id, err := strconv.ParseInt(source, 10, 64)
+	if err != nil {
+		return
+	}
+	for n, c := range config.Collections {
+		if (int64(c.SourceId) == id) {
+			p = config.Collections[n].StrmProxy
+			return
+		}
+	}
+	return
+}
+
 func getDataDir(source string) (d string) {
 	id, err := strconv.ParseInt(source, 10, 64)
 	if err != nil {
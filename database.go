@@ -5,7 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"database/sql"
-	"os"
+	"encoding/json"
+	"path"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -13,82 +14,61 @@ import (
 type DbItem struct {
 	Id		string
 	Name		string
+	Collection	string
+	Path		string
+	Title		string
+	Release		string
 	Votes		int
-	Genre		string
 	Rating		float32
 	Year		int
 	NfoTime		int64
 	FirstVideo	int64
 	LastVideo	int64
+	Dev		int64
+	Ino		int64
+	Size		int64
+}
+
+const dbItemColumns = `id, name, collection, path, title, release, votes, ` +
+	`rating, year, nfotime, firstvideo, lastvideo, dev, ino, size`
+
+// What identifies an item apart from its name.
+type itemIdent struct {
+	uniqueIds	map[string]string	// nil if the NFO was not read.
+	dev		int64
+	ino		int64
+	size		int64
 }
 
 var dbHandle *sqlx.DB
 
 func dbInit(dbFile string) (err error) {
-	dbHandle, err = sqlx.Connect("sqlite3", dbFile)
+	dbHandle, err = sqlx.Connect("sqlite3", dbFile + "?_foreign_keys=on")
 	if err == nil {
-		_, err = dbHandle.Query("SELECT count(*) FROM items")
-		if err != nil {
-			// database is empty, CREATE tables.
-			err = dbInitSchema()
-		}
+		err = dbMigrate()
 	}
 	return
 }
 
-func dbInitSchema() (error) {
-	tx, err := dbHandle.Beginx()
-	if err != nil {
-		return err
-	}
-
-	schema := `
-	CREATE TABLE items(
-		id TEXT NOT NULL PRIMARY KEY
-		name TEXT NOT NULL PRIMARY,
-		votes INTEGER,
-		year INTEGER,
-		genre TEXT NOT NULL,
-		rating REAL,
-		nfotime INTEGER NOT NULL,
-		firstvideo INTEGER NOT NULL,
-		lastvideo INTEGER NOT NULL
-	);`
-	_, err = tx.Exec(schema)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	index := "CREATE INDEX items_name_idx ON items (name);";
-	_, err = tx.Exec(index)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	tx.Commit()
-	return err
-}
-
 // Check NFO file.
-func itemCheckNfo (item *Item) (updated bool) {
+func itemCheckNfo (coll *Collection, item *Item) (updated bool) {
 	if item.NfoPath == "" {
 		return
 	}
 	if item.NfoTime > 0 {
-		fi, err := os.Stat(item.NfoPath)
+		fi, err := vfsStat(item.NfoPath)
 		if err == nil && TimeToUnixMS(fi.ModTime()) == item.NfoTime {
 			return
 		}
 	}
 
-	fh, err := os.Open(item.NfoPath)
+	fh, err := vfsOpen(item.NfoPath)
 	if err != nil {
-		fmt.Printf("XXX DEBUG open %s: %s\n", item.NfoPath, err)
+		coll.diag(diagError, item.Name, item.NfoPath,
+			"cannot open NFO: %s", err)
 		return
 	}
-	nfo := decodeNfo(fh)
+	nfo, perr := parseNfo(fh)
 	ftime := int64(0)
 	fi, err := fh.Stat()
 	if err == nil {
@@ -96,13 +76,18 @@ func itemCheckNfo (item *Item) (updated bool) {
 	}
 	fh.Close()
 	if nfo == nil {
-		fmt.Printf("XXX DEBUG XML failed %s\n", item.Name)
+		coll.diag(diagError, item.Name, item.NfoPath,
+			"cannot parse NFO: %s", perr)
 		return
 	}
 	otime := item.NfoTime
 
 	item.NfoTime = ftime
+	if nfo.Title != "" {
+		item.Title = nfo.Title
+	}
 	item.Genre = nfo.Genre
+	item.UniqueIds = nfo.uniqueIds()
 	item.Rating = nfo.Rating
 	item.Votes = nfo.Votes
 	if nfo.Year != 0 {
@@ -112,55 +97,147 @@ func itemCheckNfo (item *Item) (updated bool) {
 	return
 }
 
-func dbInsertItem(tx *sqlx.Tx, item *Item) (err error) {
-	item.Genrestring = strings.Join(item.Genre, ",")
-	_, err = tx.NamedExec(
-	`INSERT INTO items(id, name, votes, genre, rating, year, nfotime, ` +
-	`		firstvideo, lastvideo)` +
-	`VALUES (:id, :name, :votes, :genrestring, :rating, :year, :nfotime, ` +
-	`		:firstvideo, :lastvideo)`, item)
+func releaseString(r *ReleaseInfo) string {
+	if r == nil {
+		return ""
+	}
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+func dbInsertItem(tx *sqlx.Tx, coll *Collection, item *Item) (err error) {
+	_, err = tx.Exec(`INSERT INTO items(id, name, collection, type, path) ` +
+		`VALUES (?, ?, ?, ?, ?)`, item.Id, item.Name, coll.Name_,
+		item.Type, item.Path)
+	if err == nil {
+		err = dbUpdateItem(tx, item)
+	}
 	return
 }
 
 func dbUpdateItem(tx *sqlx.Tx, item *Item) (err error) {
-	item.Genrestring = strings.Join(item.Genre, ",")
+	item.Releasestring = releaseString(item.Release)
 	_, err = tx.NamedExec(
-	`UPDATE items SET votes = :votes, genre = :genrestring, rating = :rating, ` +
+	`UPDATE items SET name = :name, path = :path, ` +
+	`		title = :title, release = :releasestring, ` +
+	`		votes = :votes, rating = :rating, ` +
 	`		year = :year, nfotime = :nfotime, ` +
 	`		firstvideo = :firstvideo, lastvideo = :lastvideo ` +
-	`		WHERE name = :name`, item)
+	`		WHERE id = :id`, item)
+	if err == nil {
+		err = dbSetGenres(tx, item.Id, item.Genre)
+	}
+	if err == nil {
+		err = dbSetUniqueIds(tx, item.Id, item.UniqueIds)
+	}
 	return
 }
 
+// Replace the genres of an item.
+func dbSetGenres(tx *sqlx.Tx, id string, genres []string) (err error) {
+	_, err = tx.Exec("DELETE FROM item_genres WHERE item_id = ?", id)
+	for _, g := range genres {
+		if err != nil {
+			return
+		}
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO genres(name) VALUES (?)", g)
+		if err == nil {
+			_, err = tx.Exec(`INSERT OR IGNORE INTO item_genres(item_id, ` +
+				`genre_id) SELECT ?, id FROM genres WHERE name = ?`, id, g)
+		}
+	}
+	return
+}
 
-func dbLoadItem(coll *Collection, item *Item) {
-	var data DbItem
+func dbGetGenres(tx *sqlx.Tx, id string) (genres []string, err error) {
+	err = tx.Select(&genres, `SELECT g.name FROM item_genres ig ` +
+		`JOIN genres g ON g.id = ig.genre_id WHERE ig.item_id = ? ` +
+		`ORDER BY g.name`, id)
+	return
+}
+
+// Replace the unique ids of an item.
+func dbSetUniqueIds(tx *sqlx.Tx, id string, ids map[string]string) (err error) {
+	_, err = tx.Exec("DELETE FROM uniqueids WHERE item_id = ?", id)
+	for typ, val := range ids {
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(`INSERT INTO uniqueids(item_id, type, value) ` +
+			`VALUES (?, ?, ?)`, id, typ, val)
+	}
+	return
+}
+
+func dbGetUniqueIds(tx *sqlx.Tx, id string) (ids map[string]string, err error) {
+	var rows []struct {
+		Type	string
+		Value	string
+	}
+	err = tx.Select(&rows, "SELECT type, value FROM uniqueids WHERE item_id = ?", id)
+	if len(rows) > 0 {
+		ids = make(map[string]string)
+	}
+	for _, r := range rows {
+		ids[r.Type] = r.Value
+	}
+	return
+}
+
+// Update an item in the database in its own transaction.
+func dbSaveItem(item *Item) (err error) {
+	tx, err := dbHandle.Beginx()
+	if err != nil {
+		return
+	}
+	err = dbUpdateItem(tx, item)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	return
+}
 
-	// Find this item by name in the database.
+// Find an item in the database, and give it the id, genres etc.
+// that it has there. New items are added.
+func dbLoadItem(coll *Collection, item *Item) {
 	tx, err := dbHandle.Beginx()
-	err = dbHandle.Get(&data, "SELECT * FROM items WHERE name=? LIMIT 1", item.Name)
+	if err != nil {
+		fmt.Printf("dbLoadItem (%s): %s\n", item.Name, err)
+		item.Id = idHash(coll.Name_ + "/" + item.Name)
+		return
+	}
+	data, ident, err := dbFindItem(tx, coll, item)
 
 	// Not in database yet, insert
 	if err == sql.ErrNoRows {
-		itemCheckNfo(item)
-		// fmt.Printf("dbLoadItem: add to database: %s\n", item.Name)
-		item.Id = idHash(item.Name)
-		err = dbInsertItem(tx, item)
+		itemCheckNfo(coll, item)
+		item.Id, err = dbNewItemId(tx, coll, item)
+		if err == nil {
+			err = dbInsertItem(tx, coll, item)
+		}
+		if err == nil {
+			err = dbSetFileIdent(tx, item.Id, ident)
+		}
 		if err != nil {
-			// INSERT: error: UNIQUE constraint failed: items.id
-			// if strings.Contains(err.Error(), "UNIQUE constraint") {
+			// the item is served anyway, just not remembered.
 			fmt.Printf("dbLoadItem: INSERT: name=%s, id=%s: error: %s\n", item.Name, item.Id, err)
-			os.Exit(1)
 			tx.Rollback()
 			return
 		}
-		tx.Commit()
+		dbCommitScan(coll, tx)
 		return
 	}
 
 	// Error? Too bad.
 	if err != nil {
 		fmt.Printf("dbLoadItem (%s): %s\n", item.Name, err)
+		item.Id = idHash(coll.Name_ + "/" + item.Name)
 		tx.Rollback()
 		return
 	}
@@ -168,11 +245,44 @@ func dbLoadItem(coll *Collection, item *Item) {
 	needUpdate := false
 
 	item.Id = data.Id
-	item.Genre = strings.Split(data.Genre, ",")
+	item.Genre, _ = dbGetGenres(tx, item.Id)
+	item.UniqueIds, _ = dbGetUniqueIds(tx, item.Id)
 	item.Rating = data.Rating
 	item.Votes = data.Votes
 	item.NfoTime = data.NfoTime
 
+	// renamed or moved: keep the id.
+	if data.Name != item.Name || data.Path != item.Path {
+		if data.Name != item.Name {
+			coll.diag(diagInfo, item.Name, itemDir(coll, item),
+				"renamed from %s", data.Name)
+		}
+		needUpdate = true
+	}
+	if data.Collection != coll.Name_ {
+		_, err = tx.Exec("UPDATE items SET collection = ?, type = ? WHERE id = ?",
+			coll.Name_, item.Type, item.Id)
+		if err != nil {
+			fmt.Printf("dbLoadItem %s: update: %s\n", item.Name, err)
+			tx.Rollback()
+			return
+		}
+	}
+	if ident.uniqueIds != nil && !sameIds(ident.uniqueIds, item.UniqueIds) {
+		item.UniqueIds = ident.uniqueIds
+		needUpdate = true
+	}
+
+	// title from the NFO file wins over the one from the filename.
+	if item.NfoPath != "" && data.Title != "" {
+		item.Title = data.Title
+	} else if item.Title != data.Title {
+		needUpdate = true
+	}
+	if releaseString(item.Release) != data.Release {
+		needUpdate = true
+	}
+
 	if data.Year == 0 && item.Year > 0 {
 		needUpdate = true
 	} else {
@@ -192,7 +302,7 @@ func dbLoadItem(coll *Collection, item *Item) {
 	}
 
 	// Got it. See if we need to update the database.
-	if itemCheckNfo(item) {
+	if itemCheckNfo(coll, item) {
 		needUpdate = true
 	}
 
@@ -204,7 +314,205 @@ func dbLoadItem(coll *Collection, item *Item) {
 			return
 		}
 	}
+	if ident.dev != data.Dev || ident.ino != data.Ino || ident.size != data.Size {
+		err = dbSetFileIdent(tx, item.Id, ident)
+		if err != nil {
+			fmt.Printf("dbLoadItem %s: update: %s\n", item.Name, err)
+			tx.Rollback()
+			return
+		}
+	}
 
+	dbCommitScan(coll, tx)
+	return
+}
+
+// Commit what a scan found, unless it is a scan for a report.
+func dbCommitScan(coll *Collection, tx *sqlx.Tx) {
+	if coll.readOnly {
+		tx.Rollback()
+		return
+	}
 	tx.Commit()
+}
+
+// How an item is known in the database: by name, or in folder
+// collections by path, as names are not unique there (two folders
+// can both have an "Extras" directory).
+func dbItemKey(coll *Collection, item *Item) (column string, key string) {
+	if coll.Type == "folder" {
+		return "path", item.Path
+	}
+	return "name", item.Name
+}
+
+// Find the row of an item: by the unique ids in its NFO file, then
+// by the identity of its video file, and then by name (or path, see
+// dbItemKey). A row with another name is only taken over if its files
+// are gone, so that the item was renamed or moved, not copied (like
+// another edition of a movie, which has the same IMDb id).
+func dbFindItem(tx *sqlx.Tx, coll *Collection, item *Item) (data DbItem, ident itemIdent, err error) {
+	// rows of the first version of the database have no collection.
+	sel := "SELECT " + dbItemColumns + " FROM items "
+	scope := " AND (collection = ? OR collection = '')"
+
+	column, key := dbItemKey(coll, item)
+	err = tx.Get(&data, sel + "WHERE " + column + " = ?" + scope + " LIMIT 1",
+		key, coll.Name_)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+	byName := err == nil
+	ident.dev, ident.ino, ident.size = itemFileIdent(coll, item)
+
+	// If the NFO file did not change, neither did the unique ids,
+	// so they would get us the row we already have.
+	if byName && item.NfoPath != "" {
+		if fi, e := vfsStat(item.NfoPath); e == nil &&
+		   TimeToUnixMS(fi.ModTime()) == data.NfoTime {
+			ids, _ := dbGetUniqueIds(tx, data.Id)
+			if len(ids) > 0 {
+				return
+			}
+		}
+	}
+	if item.NfoPath != "" {
+		if nfo := loadNfo(item.NfoPath); nfo != nil {
+			ident.uniqueIds = nfo.uniqueIds()
+		}
+	}
+
+	var ids []string
+	for typ, val := range ident.uniqueIds {
+		var found []string
+		err = tx.Select(&found, `SELECT u.item_id FROM uniqueids u ` +
+			`JOIN items ON items.id = u.item_id WHERE u.type = ? ` +
+			`AND u.value = ?` + scope, typ, val, coll.Name_)
+		if err != nil {
+			return
+		}
+		ids = append(ids, found...)
+	}
+	if d, ok := dbPickItem(tx, coll, item, ids); ok {
+		data = d
+		return
+	}
+
+	ids = nil
+	if ident.ino != 0 {
+		err = tx.Select(&ids, `SELECT id FROM items WHERE dev = ? ` +
+			`AND ino = ? AND size = ?` + scope,
+			ident.dev, ident.ino, ident.size, coll.Name_)
+		if err != nil {
+			return
+		}
+	}
+	if d, ok := dbPickItem(tx, coll, item, ids); ok {
+		data = d
+		return
+	}
+
+	if !byName {
+		err = sql.ErrNoRows
+	}
 	return
 }
+
+// Of the rows that match an item, the one with the same name
+// (or path), or else one that was renamed or moved.
+func dbPickItem(tx *sqlx.Tx, coll *Collection, item *Item, ids []string) (data DbItem, ok bool) {
+	for _, id := range ids {
+		var d DbItem
+		err := tx.Get(&d, "SELECT " + dbItemColumns + " FROM items WHERE id = ?", id)
+		if err != nil {
+			continue
+		}
+		if column, key := dbItemKey(coll, item); (column == "name" && d.Name == key) ||
+		   (column == "path" && d.Path == key) {
+			return d, true
+		}
+		if !ok && dbItemGone(tx, coll, &d) {
+			data, ok = d, true
+		}
+	}
+	return
+}
+
+// Whether the files of an item in the database are gone.
+// Movies of a flat layout have no path, only a video file.
+func dbItemGone(tx *sqlx.Tx, coll *Collection, data *DbItem) bool {
+	var video string
+	tx.Get(&video, `SELECT path FROM files WHERE item_id = ? AND ` +
+		`episode_id IS NULL AND kind IN ('video', 'strm') LIMIT 1`, data.Id)
+	if data.Path == "" && video == "" {
+		return false
+	}
+	fn := path.Join(coll.Directory, unescapePath(data.Path))
+	if video != "" {
+		fn = path.Join(fn, unescapePath(video))
+	}
+	_, err := vfsStat(fn)
+	return err != nil
+}
+
+// Device, inode and size of the video of an item, or of the first
+// episode of a show.
+func itemFileIdent(coll *Collection, item *Item) (dev, ino, size int64) {
+	video := item.Video
+	for _, s := range item.Seasons {
+		for _, ep := range s.Episodes {
+			if video == "" {
+				video = ep.Video
+			}
+		}
+	}
+	if video == "" {
+		return
+	}
+	fi, err := vfsStat(path.Join(itemDir(coll, item), unescapePath(video)))
+	if err != nil {
+		return
+	}
+	dev, ino, ok := sysFileId(fi.Sys())
+	if ok {
+		size = fi.Size()
+	}
+	return
+}
+
+func dbSetFileIdent(tx *sqlx.Tx, id string, ident itemIdent) (err error) {
+	_, err = tx.Exec("UPDATE items SET dev = ?, ino = ?, size = ? WHERE id = ?",
+		ident.dev, ident.ino, ident.size, id)
+	return
+}
+
+// A new id for an item: the hash of its name (or path, see dbItemKey),
+// unless that is taken, by an item with the same name in another
+// collection for example.
+func dbNewItemId(tx *sqlx.Tx, coll *Collection, item *Item) (id string, err error) {
+	key := item.Name
+	if column, _ := dbItemKey(coll, item); column == "path" {
+		key = coll.Name_ + "/" + item.Path
+	}
+	for n := 1; ; n++ {
+		id = idHash(key)
+		var count int
+		err = tx.Get(&count, "SELECT count(*) FROM items WHERE id = ?", id)
+		if err != nil || count == 0 {
+			return
+		}
+		key = fmt.Sprintf("%s/%s/%d", coll.Name_, item.Path, n)
+	}
+}
+
+func sameIds(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,518 @@
+// Store the scanned items of a collection in the database tables:
+// items, seasons, episodes, files, subtitles, images, genres, people.
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Hash of each item as it was last stored, so that items
+// that did not change are not written again.
+var storedItems = map[string][sha256.Size]byte{}
+
+// Collections that are stored. Remote items live in the database
+// of the peer (and could have the same ids as ours).
+func isStored(coll *Collection) bool {
+	return hasSnapshot(coll) && coll.Type != "remote"
+}
+
+func dbStoreItems(tx *sqlx.Tx, coll *Collection) (err error) {
+	if !isStored(coll) {
+		return
+	}
+
+	// every scan of a collection is a new generation.
+	_, err = tx.Exec(`INSERT INTO scangens(collection, generation) ` +
+		`VALUES (?, 1) ON CONFLICT(collection) DO UPDATE SET ` +
+		`generation = generation + 1`, coll.Name_)
+	if err != nil {
+		return
+	}
+	var gen int64
+	err = tx.Get(&gen, "SELECT generation FROM scangens WHERE collection = ?",
+		coll.Name_)
+	if err != nil {
+		return
+	}
+
+	for _, item := range coll.Items {
+		sum, herr := itemHash(item)
+		if herr != nil {
+			continue
+		}
+		if storedItems[item.Id] != sum {
+			err = dbStoreItem(tx, coll, item)
+			if err != nil {
+				return
+			}
+			storedItems[item.Id] = sum
+		}
+		_, err = tx.Exec("UPDATE items SET scangen = ?, deleted = 0 WHERE id = ?",
+			gen, item.Id)
+		if err != nil {
+			return
+		}
+	}
+
+	// the items that this scan did not see are gone. Keep them for
+	// a while, in case they come back (the disk was not mounted).
+	var ids []string
+	err = tx.Select(&ids, `SELECT id FROM items WHERE collection = ? ` +
+		`AND scangen < ? AND deleted = 0`, coll.Name_, gen)
+	if err != nil {
+		return
+	}
+	now := time.Now().Unix()
+	for _, id := range ids {
+		_, err = tx.Exec("UPDATE items SET deleted = ? WHERE id = ?", now, id)
+		if err != nil {
+			return
+		}
+		delete(storedItems, id)
+	}
+	return
+}
+
+// Hash of an item. Gob encodes maps in random order, so the
+// unique ids are hashed apart, sorted.
+func itemHash(item *Item) (sum [sha256.Size]byte, err error) {
+	h := sha256.New()
+	it := *item
+	it.Children = nil
+	it.UniqueIds = nil
+	err = gob.NewEncoder(h).Encode(&it)
+	if err != nil {
+		return
+	}
+	hashUniqueIds(h, item.UniqueIds)
+	copy(sum[:], h.Sum(nil))
+	return
+}
+
+func hashUniqueIds(w io.Writer, ids map[string]string) {
+	keys := make([]string, 0, len(ids))
+	for k := range ids {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s=%s\n", k, ids[k])
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// Remove the items that have been deleted for longer than the retention.
+func dbPurgeItems() (err error) {
+	if dbHandle == nil || config.DeletedRetention <= 0 {
+		return
+	}
+	before := time.Now().Unix() - int64(config.DeletedRetention) * 86400
+	_, err = dbHandle.Exec("DELETE FROM items WHERE deleted > 0 AND deleted < ?", before)
+	return
+}
+
+// An item that is gone from disk.
+type DeletedItem struct {
+	Id		string		`json:"id"`
+	Name		string		`json:"name"`
+	Collection	string		`json:"collection"`
+	Type		string		`json:"type"`
+	Path		string		`json:"path"`
+	Deleted		time.Time	`json:"deleted"`
+	Purge		*time.Time	`json:"purge,omitempty"`
+}
+
+// The deleted items of a collection, or of all collections.
+func dbDeletedItems(collName string) (items []DeletedItem, err error) {
+	var rows []struct {
+		Id		string
+		Name		string
+		Collection	string
+		Type		string
+		Path		string
+		Deleted		int64
+	}
+	q := `SELECT id, name, collection, type, path, deleted FROM items ` +
+		`WHERE deleted > 0`
+	if collName != "" {
+		err = dbHandle.Select(&rows, q + " AND collection = ? ORDER BY name", collName)
+	} else {
+		err = dbHandle.Select(&rows, q + " ORDER BY collection, name")
+	}
+	items = []DeletedItem{}
+	for _, r := range rows {
+		d := DeletedItem{
+			Id: r.Id,
+			Name: r.Name,
+			Collection: r.Collection,
+			Type: r.Type,
+			Path: r.Path,
+			Deleted: time.Unix(r.Deleted, 0),
+		}
+		if config.DeletedRetention > 0 {
+			p := d.Deleted.AddDate(0, 0, config.DeletedRetention)
+			d.Purge = &p
+		}
+		items = append(items, d)
+	}
+	return
+}
+
+// Take an item, by id or name, off the deleted list. If its files
+// are back, the next scan serves it again. If not, it is marked as
+// deleted again, and the retention starts over.
+func dbRestoreItem(coll *Collection, key string) (err error) {
+	res, err := dbHandle.Exec(`UPDATE items SET deleted = 0 WHERE ` +
+		`collection = ? AND deleted > 0 AND (id = ? OR name = ?)`,
+		coll.Name_, key, key)
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = sql.ErrNoRows
+	}
+	return
+}
+
+// Store one item in its own transaction, after it was edited.
+func dbStoreOneItem(coll *Collection, item *Item) (err error) {
+	if !isStored(coll) {
+		return
+	}
+	tx, err := dbHandle.Beginx()
+	if err != nil {
+		return
+	}
+	err = dbStoreItem(tx, coll, item)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+func dbStoreItem(tx *sqlx.Tx, coll *Collection, item *Item) (err error) {
+	var parent interface{}
+	if item.Parent != "" {
+		parent = item.Parent
+	}
+	var peopleTime int64
+	tx.Get(&peopleTime, "SELECT peopletime FROM items WHERE id = ?", item.Id)
+
+	_, err = tx.Exec(`INSERT INTO items(id, name, collection, type, path, ` +
+	`	parent, title, sortname, release, votes, year, rating, nfotime, ` +
+	`	firstvideo, lastvideo) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ` +
+	`ON CONFLICT(id) DO UPDATE SET name = excluded.name, ` +
+	`	collection = excluded.collection, type = excluded.type, ` +
+	`	path = excluded.path, parent = excluded.parent, ` +
+	`	title = excluded.title, sortname = excluded.sortname, ` +
+	`	release = excluded.release, votes = excluded.votes, ` +
+	`	year = excluded.year, rating = excluded.rating, ` +
+	`	nfotime = excluded.nfotime, firstvideo = excluded.firstvideo, ` +
+	`	lastvideo = excluded.lastvideo`,
+		item.Id, item.Name, coll.Name_, item.Type, item.Path, parent,
+		item.Title, item.SortName, releaseString(item.Release), item.Votes,
+		item.Year, item.Rating, item.NfoTime, item.FirstVideo, item.LastVideo)
+	if err != nil {
+		return
+	}
+	err = dbSetGenres(tx, item.Id, item.Genre)
+	if err != nil {
+		return
+	}
+
+	// files and images are written again, subtitles cascade. Seasons
+	// and episodes are updated in place, they keep their ids.
+	for _, table := range []string{ "files", "images" } {
+		_, err = tx.Exec("DELETE FROM " + table + " WHERE item_id = ?", item.Id)
+		if err != nil {
+			return
+		}
+	}
+
+	dir := itemDir(coll, item)
+	var fileId int64
+	if item.Video != "" {
+		kind := "video"
+		if item.Stream != "" {
+			kind = "strm"
+		}
+		fileId, err = dbInsertFile(tx, item.Id, nil, kind, item.Video)
+		if err == nil {
+			err = dbInsertSubs(tx, fileId, item.SrtSubs, item.VttSubs)
+		}
+	}
+	if err == nil && item.Disc != nil {
+		_, err = dbInsertFile(tx, item.Id, nil, "disc", item.Disc.Path)
+	}
+	if err == nil && item.NfoPath != "" {
+		_, err = dbInsertFile(tx, item.Id, nil, "nfo", relPath(dir, item.NfoPath))
+	}
+	if err != nil {
+		return
+	}
+
+	images := artImages(item.Art, map[string]string{
+		"banner": item.Banner,
+		"fanart": item.Fanart,
+		"folder": item.Folder,
+		"poster": item.Poster,
+		"thumb": item.Thumb,
+	})
+	images = append(images, artImages(nil, map[string]string{
+		"seasonall-banner": item.SeasonAllBanner,
+		"seasonall-fanart": item.SeasonAllFanart,
+		"seasonall-poster": item.SeasonAllPoster,
+	})...)
+	err = dbInsertImages(tx, item.Id, nil, nil, images)
+	if err != nil {
+		return
+	}
+
+	seasonIds := map[string]bool{}
+	episodeIds := map[string]bool{}
+	for si := range item.Seasons {
+		s := &item.Seasons[si]
+		err = dbStoreSeason(tx, item, dir, s)
+		if err != nil {
+			return
+		}
+		seasonIds[s.Id] = true
+		for ei := range s.Episodes {
+			episodeIds[s.Episodes[ei].Id] = true
+		}
+	}
+	err = dbDeleteStale(tx, "episodes", item.Id, episodeIds)
+	if err == nil {
+		err = dbDeleteStale(tx, "seasons", item.Id, seasonIds)
+	}
+	if err != nil {
+		return
+	}
+
+	if item.NfoTime != peopleTime {
+		err = dbStorePeople(tx, item)
+	}
+	return
+}
+
+func dbStoreSeason(tx *sqlx.Tx, item *Item, dir string, s *Season) (err error) {
+	// a renumbered season has another id, make room for it.
+	_, err = tx.Exec("DELETE FROM seasons WHERE item_id = ? AND seasonno = ? " +
+		"AND id != ?", item.Id, s.SeasonNo, s.Id)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO seasons(id, item_id, seasonno) VALUES (?, ?, ?) ` +
+	`ON CONFLICT(id) DO UPDATE SET seasonno = excluded.seasonno`,
+		s.Id, item.Id, s.SeasonNo)
+	if err != nil {
+		return
+	}
+	seasonId := s.Id
+	images := artImages(s.Art, map[string]string{
+		"banner": s.Banner,
+		"fanart": s.Fanart,
+		"poster": s.Poster,
+	})
+	err = dbInsertImages(tx, item.Id, seasonId, nil, images)
+
+	for ei := range s.Episodes {
+		if err != nil {
+			return
+		}
+		ep := &s.Episodes[ei]
+		_, err = tx.Exec(`INSERT INTO episodes(id, item_id, season_id, name, ` +
+		`	seasonno, episodeno, lastepisodeno, absoluteno, airdate, ` +
+		`	double, sortname, nfotime) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ` +
+		`ON CONFLICT(id) DO UPDATE SET season_id = excluded.season_id, ` +
+		`	name = excluded.name, seasonno = excluded.seasonno, ` +
+		`	episodeno = excluded.episodeno, ` +
+		`	lastepisodeno = excluded.lastepisodeno, ` +
+		`	absoluteno = excluded.absoluteno, airdate = excluded.airdate, ` +
+		`	double = excluded.double, sortname = excluded.sortname, ` +
+		`	nfotime = excluded.nfotime`,
+			ep.Id, item.Id, seasonId, ep.Name, ep.SeasonNo, ep.EpisodeNo,
+			ep.LastEpisodeNo, ep.AbsoluteNo, ep.AirDate, ep.Double,
+			ep.SortName, ep.NfoTime)
+		if err != nil {
+			return
+		}
+		epId := ep.Id
+		var fileId int64
+		kind := "video"
+		if ep.Stream != "" {
+			kind = "strm"
+		}
+		fileId, err = dbInsertFile(tx, item.Id, epId, kind, ep.Video)
+		if err == nil {
+			err = dbInsertSubs(tx, fileId, ep.SrtSubs, ep.VttSubs)
+		}
+		if err == nil && ep.NfoPath != "" {
+			_, err = dbInsertFile(tx, item.Id, epId, "nfo", relPath(dir, ep.NfoPath))
+		}
+		if err == nil && ep.Thumb != "" {
+			err = dbInsertImages(tx, item.Id, nil, epId, [][2]string{{ "thumb", ep.Thumb }})
+		}
+	}
+	return
+}
+
+// Delete the seasons or episodes of an item that it no longer has.
+func dbDeleteStale(tx *sqlx.Tx, table string, itemId string, keep map[string]bool) (err error) {
+	var ids []string
+	err = tx.Select(&ids, "SELECT id FROM " + table + " WHERE item_id = ?", itemId)
+	for _, id := range ids {
+		if err != nil {
+			return
+		}
+		if !keep[id] {
+			_, err = tx.Exec("DELETE FROM " + table + " WHERE id = ?", id)
+		}
+	}
+	return
+}
+
+// Actors, director and writers from the NFO file.
+func dbStorePeople(tx *sqlx.Tx, item *Item) (err error) {
+	_, err = tx.Exec("DELETE FROM item_people WHERE item_id = ?", item.Id)
+	if err != nil || item.NfoPath == "" {
+		return
+	}
+	nfo := loadNfo(item.NfoPath)
+	if nfo == nil {
+		return
+	}
+	add := func(name, role, character string, ord int) {
+		name = strings.TrimSpace(name)
+		if err != nil || name == "" {
+			return
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO people(name) VALUES (?)", name)
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO item_people(item_id, person_id, ` +
+			`	role, character, ord) SELECT ?, id, ?, ?, ? FROM people ` +
+			`WHERE name = ?`, item.Id, role, character, ord, name)
+		}
+	}
+	for i, a := range nfo.Actor {
+		add(a.Name, "actor", a.Role, i)
+	}
+	for i, d := range strings.Split(nfo.Director, "/") {
+		add(d, "director", "", i)
+	}
+	for i, c := range strings.Split(nfo.Credits, "/") {
+		add(c, "writer", "", i)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE items SET peopletime = ? WHERE id = ?",
+			item.NfoTime, item.Id)
+	}
+	return
+}
+
+func dbInsertFile(tx *sqlx.Tx, itemId string, epId interface{}, kind string, p string) (id int64, err error) {
+	res, err := tx.Exec(`INSERT INTO files(item_id, episode_id, kind, path) ` +
+		`VALUES (?, ?, ?, ?)`, itemId, epId, kind, p)
+	if err == nil {
+		id, err = res.LastInsertId()
+	}
+	return
+}
+
+func dbInsertSubs(tx *sqlx.Tx, fileId int64, srt []Subs, vtt []Subs) (err error) {
+	for _, s := range srt {
+		_, err = tx.Exec(`INSERT INTO subtitles(file_id, lang, format, path) ` +
+			`VALUES (?, ?, 'srt', ?)`, fileId, s.Lang, s.Path)
+		if err != nil {
+			return
+		}
+	}
+	for _, s := range vtt {
+		_, err = tx.Exec(`INSERT INTO subtitles(file_id, lang, format, path) ` +
+			`VALUES (?, ?, 'vtt', ?)`, fileId, s.Lang, s.Path)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func dbInsertImages(tx *sqlx.Tx, itemId string, seasonId interface{}, epId interface{}, images [][2]string) (err error) {
+	for _, img := range images {
+		_, err = tx.Exec(`INSERT INTO images(item_id, season_id, episode_id, ` +
+			`kind, path) VALUES (?, ?, ?, ?, ?)`,
+			itemId, seasonId, epId, img[0], img[1])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// All images as (kind, path). If there is no `art', use `basic'.
+func artImages(art *Art, basic map[string]string) (images [][2]string) {
+	if art != nil {
+		basic = map[string]string{
+			"banner": art.Banner,
+			"characterart": art.Characterart,
+			"clearart": art.Clearart,
+			"clearlogo": art.Clearlogo,
+			"discart": art.Discart,
+			"fanart": art.Fanart,
+			"folder": art.Folder,
+			"keyart": art.Keyart,
+			"landscape": art.Landscape,
+			"poster": art.Poster,
+			"thumb": art.Thumb,
+		}
+	}
+	for kind, p := range basic {
+		if p != "" {
+			images = append(images, [2]string{ kind, p })
+		}
+	}
+	if art != nil {
+		for _, p := range art.Extrafanart {
+			images = append(images, [2]string{ "extrafanart", p })
+		}
+	}
+	return
+}
+
+// Path of a file relative to the item, like the other paths.
+func relPath(dir string, fn string) string {
+	if strings.HasPrefix(fn, dir + "/") {
+		fn = fn[len(dir) + 1:]
+	}
+	return escapePath(path.Clean(fn))
+}
+
+// Number of items per genre in a collection.
+func dbGenreCounts(coll *Collection) (gc map[string]int, err error) {
+	var rows []struct {
+		Name	string
+		Count	int
+	}
+	err = dbHandle.Select(&rows, `SELECT g.name, count(*) AS count ` +
+		`FROM item_genres ig JOIN genres g ON g.id = ig.genre_id ` +
+		`JOIN items i ON i.id = ig.item_id WHERE i.collection = ? ` +
+		`AND i.deleted = 0 ` +
+		`GROUP BY g.name`, coll.Name_)
+	gc = make(map[string]int)
+	for _, r := range rows {
+		gc[r.Name] = r.Count
+	}
+	return
+}
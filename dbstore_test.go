@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+)
+
+// The same item always hashes the same, whatever order gob
+// would put the unique ids in.
+func TestItemHash(t *testing.T) {
+	ids := map[string]string{}
+	for _, k := range []string{ "imdb", "tmdb", "tvdb", "anidb", "mal", "trakt" } {
+		ids[k] = k + "-1"
+	}
+	item := &Item{ Id: "x", Name: "Alien (1979)", UniqueIds: ids }
+	want, err := itemHash(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if sum, _ := itemHash(item); sum != want {
+			t.Fatalf("hash changed on run %d", i)
+		}
+	}
+	snap := &snapshot{ Type: "movies", Items: []*Item{ item } }
+	want, _ = snapshotHash(snap)
+	for i := 0; i < 20; i++ {
+		if sum, _ := snapshotHash(snap); sum != want {
+			t.Fatalf("snapshot hash changed on run %d", i)
+		}
+	}
+	if item.UniqueIds == nil {
+		t.Errorf("unique ids not restored")
+	}
+	item.UniqueIds = map[string]string{ "imdb": "tt1" }
+	if sum, _ := snapshotHash(snap); sum == want {
+		t.Errorf("hash did not change with the ids")
+	}
+}
+
+// Seasons and episodes are stored with their own ids, and keep them
+// when the show is stored again.
+func TestStoreEpisodeIds(t *testing.T) {
+	testDb(t)
+	coll := &Collection{ Name_: "test", Type: "shows", Directory: "/tv" }
+	show := &Item{ Id: "show", Name: "Show", Type: "show", Path: "Show" }
+	ep := func(s, e int) Episode {
+		return Episode{ Video: "e.mp4", SeasonNo: s, EpisodeNo: e }
+	}
+	show.Seasons = []Season{
+		{ SeasonNo: 1, Episodes: []Episode{ ep(1, 1), ep(1, 2) }},
+		{ SeasonNo: 2, Episodes: []Episode{ ep(2, 1) }},
+	}
+	show.setEpisodeIds()
+
+	store := func() (ids []string) {
+		tx, err := dbHandle.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = dbStoreItem(tx, coll, show); err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+		tx.Select(&ids, "SELECT id FROM episodes ORDER BY seasonno, episodeno")
+		tx.Commit()
+		return
+	}
+	ids := store()
+	if len(ids) != 3 || ids[0] != show.Seasons[0].Episodes[0].Id {
+		t.Fatalf("episode ids %v", ids)
+	}
+	if ids2 := store(); len(ids2) != 3 || ids2[2] != ids[2] {
+		t.Errorf("ids changed: %v %v", ids, ids2)
+	}
+
+	show.Seasons = show.Seasons[:1]
+	if ids = store(); len(ids) != 2 {
+		t.Errorf("stale episodes kept: %v", ids)
+	}
+	var seasons int
+	dbHandle.Get(&seasons, "SELECT count(*) FROM seasons")
+	if seasons != 1 {
+		t.Errorf("got %d seasons, want 1", seasons)
+	}
+}
+
+// A scan that found nothing new, or that failed, stores nothing.
+func TestStoreSkip(t *testing.T) {
+	testDb(t)
+	saved := config.Collections
+	defer func() { config.Collections = saved }()
+	config.Collections = []Collection{{
+		Name_: "test",
+		Type: "movies",
+		Directory: testMountMem(t, testMovieFiles),
+	}}
+	c := &config.Collections[0]
+	gen := func() (g int) {
+		dbHandle.Get(&g, "SELECT generation FROM scangens WHERE collection = ?", "test")
+		return
+	}
+
+	updateCollection(c, 1, 0)
+	if gen() != 1 {
+		t.Fatalf("first scan: generation %d", gen())
+	}
+	updateCollection(c, 1, 0)
+	if gen() != 1 {
+		t.Errorf("unchanged scan stored: generation %d", gen())
+	}
+
+	c.Directory = "/mem/does-not-exist"
+	updateCollection(c, 1, 0)
+	if !c.scanFailed() {
+		t.Fatalf("scan did not fail")
+	}
+	var deleted int
+	dbHandle.Get(&deleted, "SELECT count(*) FROM items WHERE deleted > 0")
+	if gen() != 1 || deleted != 0 {
+		t.Errorf("failed scan stored: generation %d, %d deleted", gen(), deleted)
+	}
+}
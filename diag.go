@@ -0,0 +1,171 @@
+// Scan diagnostics: problems found while scanning the library,
+// like NFO files that do not parse or directories we cannot read.
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	diagError	= "error"
+	diagWarning	= "warning"
+	diagInfo	= "info"
+)
+
+type Diagnostic struct {
+	Severity	string		`json:"severity"`
+	Item		string		`json:"item,omitempty"`
+	Path		string		`json:"path,omitempty"`
+	Message		string		`json:"message"`
+}
+
+// Diagnostics of a collection. `current' is being filled by a
+// running scan, `last' is the result of the last complete scan.
+type scanDiags struct {
+	sync.Mutex
+	current		[]Diagnostic
+	last		[]Diagnostic
+	started		time.Time
+	finished	time.Time
+	complete	bool
+	// the scan missed part of the collection, see scanError.
+	failed		bool
+}
+
+// Diagnostics in a report, grouped by severity and then by item.
+type HealthReport struct {
+	Collection	string		`json:"collection"`
+	ScanStarted	time.Time	`json:"scanstarted"`
+	ScanFinished	*time.Time	`json:"scanfinished,omitempty"`
+	Counts		map[string]int	`json:"counts"`
+	Severity	map[string]map[string][]Diagnostic	`json:"severity"`
+}
+
+func (coll *Collection) diags() *scanDiags {
+	if coll.scanDiags == nil {
+		coll.scanDiags = &scanDiags{}
+	}
+	return coll.scanDiags
+}
+
+func (coll *Collection) diagBegin() {
+	d := coll.diags()
+	d.Lock()
+	d.current = nil
+	d.started = time.Now()
+	d.failed = false
+	d.Unlock()
+}
+
+func (coll *Collection) diagEnd() {
+	d := coll.diags()
+	d.Lock()
+	d.last = d.current
+	d.current = nil
+	d.finished = time.Now()
+	d.complete = true
+	d.Unlock()
+}
+
+// Add a diagnostic. `item' is the name of the item, or empty for
+// problems with the collection itself.
+func (coll *Collection) diag(severity string, item string, path string, format string, args ...interface{}) {
+	d := coll.diags()
+	d.Lock()
+	d.current = append(d.current, Diagnostic{
+		Severity: severity,
+		Item: item,
+		Path: path,
+		Message: fmt.Sprintf(format, args...),
+	})
+	d.Unlock()
+}
+
+// An error that makes the scan incomplete, like a directory that
+// cannot be read. The items in it are not gone, it just looks that way.
+func (coll *Collection) scanError(item string, path string, format string, args ...interface{}) {
+	d := coll.diags()
+	d.Lock()
+	d.failed = true
+	d.Unlock()
+	coll.diag(diagError, item, path, format, args...)
+}
+
+func (coll *Collection) scanFailed() bool {
+	d := coll.diags()
+	d.Lock()
+	defer d.Unlock()
+	return d.failed
+}
+
+// Report of the last complete scan, or of the running one
+// if there has not been a complete scan yet.
+func (coll *Collection) healthReport() (rep HealthReport) {
+	d := coll.diags()
+	d.Lock()
+	list := d.last
+	rep.ScanStarted = d.started
+	if d.complete {
+		fin := d.finished
+		rep.ScanFinished = &fin
+	} else {
+		list = append([]Diagnostic{}, d.current...)
+	}
+	d.Unlock()
+
+	rep.Collection = coll.Name_
+	rep.Counts = make(map[string]int)
+	rep.Severity = make(map[string]map[string][]Diagnostic)
+	for _, diag := range list {
+		rep.Counts[diag.Severity]++
+		items, ok := rep.Severity[diag.Severity]
+		if !ok {
+			items = make(map[string][]Diagnostic)
+			rep.Severity[diag.Severity] = items
+		}
+		item := diag.Item
+		if item == "" {
+			item = "-"
+		}
+		items[item] = append(items[item], diag)
+	}
+	return
+}
+
+func printHealthReport(w io.Writer, rep HealthReport) {
+	fmt.Fprintf(w, "== %s\n", rep.Collection)
+	for _, sev := range []string{ diagError, diagWarning, diagInfo } {
+		items := rep.Severity[sev]
+		names := make([]string, 0, len(items))
+		for name := range items {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, d := range items[name] {
+				fmt.Fprintf(w, "%s: %s: %s", sev, name, d.Message)
+				if d.Path != "" {
+					fmt.Fprintf(w, " (%s)", d.Path)
+				}
+				fmt.Fprintf(w, "\n")
+			}
+		}
+	}
+}
+
+// Scan the collections (or just `collName') and print the report.
+// The database is not changed.
+func healthReportCLI(w io.Writer, collName string) {
+	for i := range config.Collections {
+		c := &(config.Collections[i])
+		if collName != "" && c.Name_ != collName {
+			continue
+		}
+		scanForReport(c, i + 1)
+		printHealthReport(w, c.healthReport())
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The report scans, but does not write to the database.
+func TestHealthReportCLI(t *testing.T) {
+	testDb(t)
+	saved := config.Collections
+	defer func() { config.Collections = saved }()
+	config.Collections = []Collection{{
+		Name_: "test",
+		Type: "movies",
+		Directory: testMountMem(t, map[string]string{
+			"Alien (1979)/Alien (1979).mp4": "video",
+			"Alien (1979)/Alien (1979).nfo": "<movie><title>",
+		}),
+	}}
+	var buf bytes.Buffer
+	healthReportCLI(&buf, "")
+	if !strings.Contains(buf.String(), "cannot parse NFO") {
+		t.Errorf("report: %q", buf.String())
+	}
+	for _, table := range []string{ "items", "snapshots", "scangens" } {
+		var n int
+		dbHandle.Get(&n, "SELECT count(*) FROM " + table)
+		if n != 0 {
+			t.Errorf("%s: %d rows written", table, n)
+		}
+	}
+}
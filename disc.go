@@ -0,0 +1,163 @@
+// Disc backups: DVD (VIDEO_TS), Blu-ray (BDMV) and .iso images.
+//
+// Browsers cannot play these, but clients can offer a download
+// or hand the main title to an external player.
+package main
+
+import (
+	"encoding/binary"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var isIso = regexp.MustCompile(`(?i)^(.*)\.iso$`)
+var isVob = regexp.MustCompile(`(?i)^VTS_([0-9]{2})_([0-9])\.VOB$`)
+var isM2ts = regexp.MustCompile(`(?i)^[0-9]+\.m2ts$`)
+
+type DiscInfo struct {
+	Type		string		`json:"type"`
+	Path		string		`json:"path"`
+	MainTitle	string		`json:"maintitle,omitempty"`
+	Duration	int		`json:"duration,omitempty"`
+	Size		int64		`json:"size"`
+}
+
+// Is this the name of a disc structure (or image) in a movie directory.
+func isDiscName(name string) bool {
+	switch strings.ToUpper(name) {
+	case "VIDEO_TS", "BDMV":
+		return true
+	}
+	return isIso.MatchString(name)
+}
+
+// Look for a disc structure in movie directory `d'.
+func findDisc(coll *Collection, mname string, d string, fi []FileInfo) (disc *DiscInfo, created int64) {
+	for i := range fi {
+		name := fi[i].Name()
+		switch {
+		case fi[i].IsDir() && strings.ToUpper(name) == "VIDEO_TS":
+			disc = scanDvd(path.Join(d, name))
+		case fi[i].IsDir() && strings.ToUpper(name) == "BDMV":
+			disc = scanBluray(path.Join(d, name))
+		case !fi[i].IsDir() && isIso.MatchString(name):
+			disc = &DiscInfo{
+				Type: "iso",
+				Size: fi[i].Size(),
+			}
+		default:
+			continue
+		}
+		if disc == nil {
+			coll.diag(diagWarning, mname, path.Join(d, name),
+				"cannot find the main title")
+			continue
+		}
+		disc.Path = escapePath(name)
+		if disc.MainTitle != "" {
+			disc.MainTitle = escapePath(path.Join(name, disc.MainTitle))
+		}
+		created = fi[i].CreatetimeMS()
+		return
+	}
+	return
+}
+
+func readDirInfo(d string) (fi []FileInfo) {
+	f, err := OpenDir(d)
+	if err != nil {
+		return
+	}
+	fi, _ = f.Readdir(0)
+	f.Close()
+	return
+}
+
+// The main title of a DVD is the title set with the most data
+// in it. VTS_nn_0.VOB is the menu, the rest is the movie.
+func scanDvd(d string) (disc *DiscInfo) {
+	sizes := map[string]int64{}
+	var total int64
+	for _, f := range readDirInfo(d) {
+		total += f.Size()
+		s := isVob.FindStringSubmatch(f.Name())
+		if len(s) > 0 && s[2] != "0" {
+			sizes[s[1]] += f.Size()
+		}
+	}
+	main := ""
+	for vts, size := range sizes {
+		if main == "" || size > sizes[main] || (size == sizes[main] && vts < main) {
+			main = vts
+		}
+	}
+	if main == "" {
+		return
+	}
+	disc = &DiscInfo{
+		Type: "dvd",
+		MainTitle: "VTS_" + main + "_1.VOB",
+		Duration: dvdDuration(path.Join(d, "VTS_" + main + "_0.IFO")),
+		Size: total,
+	}
+	return
+}
+
+// Longest program chain in the IFO file of a title set, in seconds.
+func dvdDuration(fn string) (secs int) {
+	f, err := vfsOpen(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var b [8]byte
+	if _, err := f.ReadAt(b[:4], 0xcc); err != nil {
+		return
+	}
+	pgcit := int64(binary.BigEndian.Uint32(b[:4])) * 2048
+	if _, err := f.ReadAt(b[:2], pgcit); err != nil {
+		return
+	}
+	n := int(binary.BigEndian.Uint16(b[:2]))
+	bcd := func(c byte) int {
+		return int(c >> 4) * 10 + int(c & 0x0f)
+	}
+	for i := 0; i < n && i < 256; i++ {
+		if _, err := f.ReadAt(b[:], pgcit + 8 + int64(i) * 8); err != nil {
+			return
+		}
+		pgc := pgcit + int64(binary.BigEndian.Uint32(b[4:8]))
+		var t [4]byte
+		if _, err := f.ReadAt(t[:], pgc + 4); err != nil {
+			return
+		}
+		s := bcd(t[0]) * 3600 + bcd(t[1]) * 60 + bcd(t[2])
+		if s > secs {
+			secs = s
+		}
+	}
+	return
+}
+
+// The main title of a Blu-ray is the biggest stream.
+func scanBluray(d string) (disc *DiscInfo) {
+	var total, max int64
+	main := ""
+	for _, f := range readDirInfo(path.Join(d, "STREAM")) {
+		total += f.Size()
+		if isM2ts.MatchString(f.Name()) && f.Size() > max {
+			main, max = f.Name(), f.Size()
+		}
+	}
+	if main == "" {
+		return
+	}
+	disc = &DiscInfo{
+		Type: "bluray",
+		MainTitle: path.Join("STREAM", main),
+		Size: total,
+	}
+	return
+}
@@ -0,0 +1,326 @@
+// Read EXIF metadata and the image size from JPEG and PNG files.
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+type ExifInfo struct {
+	Taken		time.Time
+	Make		string
+	Model		string
+	Lens		string
+	Orientation	int
+	Width		int
+	Height		int
+	Gps		*GpsInfo
+}
+
+type GpsInfo struct {
+	Latitude	float64		`json:"latitude"`
+	Longitude	float64		`json:"longitude"`
+	Altitude	float64		`json:"altitude,omitempty"`
+}
+
+// Camera, like "Canon EOS 80D". Most cameras repeat the
+// make in the model, some do not.
+func (e *ExifInfo) Camera() string {
+	if e.Make == "" || strings.HasPrefix(strings.ToLower(e.Model), strings.ToLower(e.Make)) {
+		return e.Model
+	}
+	return strings.TrimSpace(e.Make + " " + e.Model)
+}
+
+// Does the image need to be rotated 90 or 270 degrees.
+func (e *ExifInfo) rotated() bool {
+	return e.Orientation >= 5 && e.Orientation <= 8
+}
+
+// Read the EXIF info of an image. Returns nil if it is
+// not an image we know about.
+func readExif(r io.ReaderAt) (info *ExifInfo) {
+	var magic [8]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return
+	}
+	switch {
+	case magic[0] == 0xff && magic[1] == 0xd8:
+		info = readJpegExif(r)
+	case string(magic[:]) == "\x89PNG\r\n\x1a\n":
+		info = readPngExif(r)
+	}
+	return
+}
+
+// Walk the JPEG segments up to the start of the image data.
+// APP1 has the EXIF data, SOFn the size of the image.
+func readJpegExif(r io.ReaderAt) (info *ExifInfo) {
+	info = &ExifInfo{}
+	var hdr [4]byte
+	for pos := int64(2); ; {
+		if _, err := r.ReadAt(hdr[:], pos); err != nil || hdr[0] != 0xff {
+			return
+		}
+		marker := hdr[1]
+		l := int64(binary.BigEndian.Uint16(hdr[2:4]))
+		if marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 {
+			pos += 2
+			continue
+		}
+		if marker == 0xd9 || marker == 0xda || l < 2 {
+			return
+		}
+		switch {
+		case marker == 0xe1 && l > 8 && l <= maxTagSize:
+			b := make([]byte, l - 2)
+			if _, err := r.ReadAt(b, pos + 4); err != nil {
+				return
+			}
+			if string(b[:6]) == "Exif\x00\x00" {
+				parseTiff(b[6:], info)
+			}
+		case marker >= 0xc0 && marker <= 0xcf &&
+		     marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			var sof [5]byte
+			if _, err := r.ReadAt(sof[:], pos + 4); err != nil {
+				return
+			}
+			info.Height = int(binary.BigEndian.Uint16(sof[1:3]))
+			info.Width = int(binary.BigEndian.Uint16(sof[3:5]))
+			return
+		}
+		pos += 2 + l
+	}
+}
+
+// PNG has the size in the IHDR chunk, and can have an eXIf chunk.
+func readPngExif(r io.ReaderAt) (info *ExifInfo) {
+	info = &ExifInfo{}
+	var hdr [8]byte
+	for pos := int64(8); ; {
+		if _, err := r.ReadAt(hdr[:], pos); err != nil {
+			return
+		}
+		l := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		switch string(hdr[4:8]) {
+		case "IHDR":
+			var b [8]byte
+			if _, err := r.ReadAt(b[:], pos + 8); err != nil {
+				return
+			}
+			info.Width = int(binary.BigEndian.Uint32(b[0:4]))
+			info.Height = int(binary.BigEndian.Uint32(b[4:8]))
+		case "eXIf":
+			if l <= maxTagSize {
+				b := make([]byte, l)
+				if _, err := r.ReadAt(b, pos + 8); err == nil {
+					parseTiff(b, info)
+				}
+			}
+		case "IDAT", "IEND":
+			return
+		}
+		pos += 12 + l
+	}
+}
+
+type tiffReader struct {
+	b	[]byte
+	bo	binary.ByteOrder
+}
+
+type tiffEntry struct {
+	tag	uint16
+	typ	uint16
+	count	uint32
+	value	[]byte
+}
+
+var tiffTypeSize = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8,
+}
+
+// Read an IFD. Returns nil on errors.
+func (t *tiffReader) ifd(off uint32) (entries []tiffEntry) {
+	b := t.b
+	if int(off) + 2 > len(b) {
+		return
+	}
+	n := int(t.bo.Uint16(b[off:]))
+	for i := 0; i < n; i++ {
+		p := int(off) + 2 + 12 * i
+		if p + 12 > len(b) {
+			return
+		}
+		e := tiffEntry{
+			tag: t.bo.Uint16(b[p:]),
+			typ: t.bo.Uint16(b[p+2:]),
+			count: t.bo.Uint32(b[p+4:]),
+		}
+		size := tiffTypeSize[e.typ] * int(e.count)
+		if size <= 0 || size > len(b) {
+			continue
+		}
+		if size <= 4 {
+			e.value = b[p+8:p+8+size]
+		} else {
+			o := int(t.bo.Uint32(b[p+8:]))
+			if o < 0 || o + size > len(b) {
+				continue
+			}
+			e.value = b[o:o+size]
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+func (t *tiffReader) uint(e tiffEntry) uint32 {
+	switch e.typ {
+	case 3:
+		return uint32(t.bo.Uint16(e.value))
+	case 4, 9:
+		return t.bo.Uint32(e.value)
+	case 1, 7:
+		return uint32(e.value[0])
+	}
+	return 0
+}
+
+func (t *tiffReader) rational(e tiffEntry, i int) float64 {
+	if (e.typ != 5 && e.typ != 10) || len(e.value) < 8 * (i + 1) {
+		return 0
+	}
+	n := t.bo.Uint32(e.value[8*i:])
+	d := t.bo.Uint32(e.value[8*i+4:])
+	if d == 0 {
+		return 0
+	}
+	if e.typ == 10 {
+		return float64(int32(n)) / float64(int32(d))
+	}
+	return float64(n) / float64(d)
+}
+
+func (t *tiffReader) string(e tiffEntry) string {
+	return cString(string(e.value))
+}
+
+// EXIF dates look like "2019:08:17 14:31:07", in local time.
+func exifTime(s string, offset string) (tm time.Time) {
+	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		tm, _ = time.Parse(layout + "-07:00", s + offset)
+		if !tm.IsZero() {
+			return
+		}
+	}
+	tm, _ = time.ParseInLocation(layout, s, time.Local)
+	return
+}
+
+// Parse the TIFF structure inside the EXIF data.
+func parseTiff(b []byte, info *ExifInfo) {
+	if len(b) < 8 {
+		return
+	}
+	t := &tiffReader{ b: b }
+	switch string(b[:2]) {
+	case "II":
+		t.bo = binary.LittleEndian
+	case "MM":
+		t.bo = binary.BigEndian
+	default:
+		return
+	}
+
+	var dateTime, dateOriginal, offset string
+	var exifIfd, gpsIfd uint32
+	for _, e := range t.ifd(t.bo.Uint32(b[4:])) {
+		switch e.tag {
+		case 0x010f:
+			info.Make = t.string(e)
+		case 0x0110:
+			info.Model = t.string(e)
+		case 0x0112:
+			info.Orientation = int(t.uint(e))
+		case 0x0132:
+			dateTime = t.string(e)
+		case 0x8769:
+			exifIfd = t.uint(e)
+		case 0x8825:
+			gpsIfd = t.uint(e)
+		}
+	}
+	if exifIfd > 0 {
+		for _, e := range t.ifd(exifIfd) {
+			switch e.tag {
+			case 0x9003:
+				dateOriginal = t.string(e)
+			case 0x9011:
+				offset = t.string(e)
+			case 0xa002:
+				info.Width = int(t.uint(e))
+			case 0xa003:
+				info.Height = int(t.uint(e))
+			case 0xa434:
+				info.Lens = t.string(e)
+			}
+		}
+	}
+	if dateOriginal != "" {
+		info.Taken = exifTime(dateOriginal, offset)
+	}
+	if info.Taken.IsZero() && dateTime != "" {
+		info.Taken = exifTime(dateTime, "")
+	}
+
+	if gpsIfd > 0 {
+		var latRef, lonRef string
+		var lat, lon, alt float64
+		var haveLat, haveLon, below bool
+		for _, e := range t.ifd(gpsIfd) {
+			switch e.tag {
+			case 1:
+				latRef = t.string(e)
+			case 2:
+				lat = t.rational(e, 0) + t.rational(e, 1) / 60 +
+					t.rational(e, 2) / 3600
+				haveLat = true
+			case 3:
+				lonRef = t.string(e)
+			case 4:
+				lon = t.rational(e, 0) + t.rational(e, 1) / 60 +
+					t.rational(e, 2) / 3600
+				haveLon = true
+			case 5:
+				below = t.uint(e) == 1
+			case 6:
+				alt = t.rational(e, 0)
+			}
+		}
+		if haveLat && haveLon && !(lat == 0 && lon == 0) {
+			if latRef == "S" {
+				lat = -lat
+			}
+			if lonRef == "W" {
+				lon = -lon
+			}
+			if below {
+				alt = -alt
+			}
+			round := func(f float64) float64 {
+				return math.Round(f * 1e6) / 1e6
+			}
+			info.Gps = &GpsInfo{
+				Latitude: round(lat),
+				Longitude: round(lon),
+				Altitude: math.Round(alt * 10) / 10,
+			}
+		}
+	}
+}
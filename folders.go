@@ -0,0 +1,144 @@
+// Collections of type "folder" mirror the directory hierarchy.
+//
+// Every directory is a movie, a show, or a folder with more
+// directories in it. All of them end up in the collection's
+// item list, with `parent' set to the id of the folder they are in.
+package main
+
+import (
+	"path"
+	"time"
+)
+
+// protection against symlink loops.
+const maxFolderDepth = 16
+
+func buildFolders(coll *Collection, pace int) (items []*Item) {
+	ign := newIgnoreMatcher(coll)
+	root := &Item{}
+	buildFolderItems(coll, ign, "", root, &items, pace, 0)
+	coll.Items = items
+	return
+}
+
+// Decide if a directory is a "movie", a "show" or a "folder".
+func folderEntryType(coll *Collection, ign *ignoreMatcher, dir string) (t string) {
+	d := path.Join(coll.Directory, dir)
+	f, err := OpenDir(d)
+	if err != nil {
+		if !isNotDirectory(err) {
+			coll.scanError(path.Base(dir), d,
+				"cannot read directory: %s", err)
+		}
+		return
+	}
+	defer f.Close()
+	fi, _ := f.Readdir(0)
+	fi = ign.sub(d).filter(d, fi)
+
+	videos, episodes, subdirs := 0, 0, 0
+	for i := range fi {
+		name := fi[i].Name()
+		if isDiscName(name) {
+			videos++
+			continue
+		}
+		if name == "tvshow.nfo" || isShowSubdir.MatchString(name) {
+			t = "show"
+			return
+		}
+		if s := isVideo.FindStringSubmatch(name); len(s) > 0 {
+			videos++
+			if parseEpisodeInfo(coll, s[1], -1) != nil {
+				episodes++
+			}
+			continue
+		}
+		if name != "extrafanart" && fi[i].IsDir() {
+			subdirs++
+		}
+	}
+	switch {
+	case episodes > 1:
+		t = "show"
+	case videos > 0:
+		t = "movie"
+	case subdirs > 0:
+		t = "folder"
+	}
+	return
+}
+
+// Add the items in directory `dir' to `items'.
+func buildFolderItems(coll *Collection, ign *ignoreMatcher, dir string, parent *Item, items *[]*Item, pace int, depth int) {
+	d := path.Join(coll.Directory, dir)
+	f, err := OpenDir(d)
+	if err != nil {
+		coll.scanError("", d, "cannot read directory: %s", err)
+		return
+	}
+	defer f.Close()
+	fi, _ := f.Readdir(0)
+	ign = ign.sub(d)
+	fi = ign.filter(d, fi)
+
+	for i := range fi {
+		name := fi[i].Name()
+
+		// images of the folder itself.
+		if s := isImage.FindStringSubmatch(name); len(s) > 0 {
+			if parent.Type == "folder" {
+				parent.setArt(s[1], escapePath(path.Join(dir, name)))
+			}
+			continue
+		}
+
+		sub := path.Join(dir, name)
+		var item *Item
+		switch folderEntryType(coll, ign, sub) {
+		case "movie":
+			item = buildMovie(coll, ign, sub)
+		case "show":
+			item = buildShow(coll, ign, sub)
+		case "folder":
+			if depth >= maxFolderDepth {
+				coll.diag(diagWarning, name, path.Join(d, name),
+					"folders nested too deep")
+				continue
+			}
+			item = &Item{
+				// the same path can be in another folder collection.
+				Id: idHash("folder:" + coll.Name_ + "/" + sub),
+				Name: name,
+				Title: name,
+				BaseUrl: coll.BaseUrl,
+				Path: escapePath(sub),
+				Type: "folder",
+			}
+			buildFolderItems(coll, ign, sub, item, items, pace, depth + 1)
+			if len(item.Children) == 0 {
+				item = nil
+			}
+		}
+		if item == nil {
+			continue
+		}
+		item.Parent = parent.Id
+		parent.Children = append(parent.Children, item)
+		*items = append(*items, item)
+
+		// the folder is as new as the newest video in it.
+		if item.FirstVideo > 0 &&
+		   (parent.FirstVideo == 0 || item.FirstVideo < parent.FirstVideo) {
+			parent.FirstVideo = item.FirstVideo
+		}
+		if item.LastVideo > parent.LastVideo {
+			parent.LastVideo = item.LastVideo
+		}
+
+		if pace > 0 && item.Type != "folder" {
+			d := time.Duration(int64(pace)) * time.Second
+			time.Sleep(d)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+// The same folder in two collections is two items.
+func TestFolderIds(t *testing.T) {
+	testDb(t)
+	files := map[string]string{
+		"Docs/Alien (1979)/Alien (1979).mp4": "video",
+		"Docs/Heat (1995)/Heat (1995).mp4": "video",
+	}
+	ids := map[string]string{}
+	for _, name := range []string{ "one", "two" } {
+		t.Run(name, func(t *testing.T) {
+			coll := &Collection{
+				Name_: name,
+				Type: "folder",
+				Directory: testMountMem(t, files),
+			}
+			for _, item := range buildFolders(coll, 0) {
+				if item.Type == "folder" && item.Name == "Docs" {
+					ids[name] = item.Id
+				}
+			}
+			if ids[name] == "" {
+				t.Fatalf("no folder item")
+			}
+		})
+	}
+	if ids["one"] == ids["two"] {
+		t.Errorf("same id %s in both collections", ids["one"])
+	}
+}
@@ -0,0 +1,195 @@
+// Collections of type "homevideos".
+//
+// Camcorder and phone videos have no NFO files and names like
+// VID_20190817_143107.mp4. They are sorted by recording date instead
+// of by title. Every directory is an "event", with the clips and
+// the events below it as children, like in a "folder" collection.
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Dates in filenames: 20190817_143107, 2019-08-17 14.31.07, 2019-08-17.
+var isRecordedDate = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{2})[-_.]?([01][0-9])[-_.]?([0-3][0-9])(?:[-_. T]?([0-2][0-9])[-_.:]?([0-5][0-9])[-_.:]?([0-5][0-9]))?(?:[^0-9]|$)`)
+
+// Names that cameras make up. Those are no use as a title.
+var isCameraName = regexp.MustCompile(`(?i)^(?:vid|mvi|mov|img|pxl|dsc|dscf|gopr|gp|gh|dji|mah|clip|video)?[-_ ]?[0-9]+(?:[-_ ][0-9a-z]+)*$`)
+
+func buildHomeVideos(coll *Collection, pace int) (items []*Item) {
+	ign := newIgnoreMatcher(coll)
+	root := &Item{}
+	buildEventItems(coll, ign, "", root, &items, pace, 0)
+	coll.Items = items
+	return
+}
+
+// Add the clips and events in directory `dir' to `items'.
+func buildEventItems(coll *Collection, ign *ignoreMatcher, dir string, parent *Item, items *[]*Item, pace int, depth int) {
+	d := path.Join(coll.Directory, dir)
+	f, err := OpenDir(d)
+	if err != nil {
+		coll.scanError("", d, "cannot read directory: %s", err)
+		return
+	}
+	fi, _ := f.Readdir(0)
+	f.Close()
+	ign = ign.sub(d)
+	fi = ign.filter(d, fi)
+
+	var children []*Item
+	for i := range fi {
+		name := fi[i].Name()
+
+		if fi[i].IsDir() {
+			if depth >= maxFolderDepth {
+				coll.diag(diagWarning, name, path.Join(d, name),
+					"directories nested too deep")
+				continue
+			}
+			sub := path.Join(dir, name)
+			event := &Item{
+				Id: idHash("event:" + coll.Name_ + "/" + sub),
+				Name: name,
+				Title: name,
+				BaseUrl: coll.BaseUrl,
+				Path: escapePath(sub),
+				Type: "event",
+			}
+			buildEventItems(coll, ign, sub, event, items, pace, depth + 1)
+			if len(event.Children) > 0 {
+				children = append(children, event)
+			}
+			continue
+		}
+
+		s := isVideo.FindStringSubmatch(name)
+		if len(s) == 0 {
+			// images of the event itself.
+			if s := isImage.FindStringSubmatch(name); len(s) > 0 &&
+			   parent.Type == "event" {
+				parent.setArt(s[1], escapePath(path.Join(dir, name)))
+			}
+			continue
+		}
+		var files []FileInfo
+		for _, f := range fi {
+			if !f.IsDir() && isSidecar(f.Name(), s[1]) {
+				files = append(files, f)
+			}
+		}
+		clip := buildHomeVideo(coll, ign, dir, &fi[i], files)
+		children = append(children, clip)
+		*items = append(*items, clip)
+
+		if pace > 0 {
+			time.Sleep(time.Duration(int64(pace)) * time.Second)
+		}
+	}
+
+	// oldest first. Events sort by their first clip.
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].SortName < children[j].SortName
+	})
+	for _, item := range children {
+		item.Parent = parent.Id
+		parent.Children = append(parent.Children, item)
+		if item.Type == "event" {
+			*items = append(*items, item)
+		}
+		if parent.SortName == "" || item.SortName < parent.SortName {
+			parent.Recorded = item.Recorded
+			parent.SortName = item.SortName
+			parent.Year = item.Year
+		}
+		if item.FirstVideo > 0 &&
+		   (parent.FirstVideo == 0 || item.FirstVideo < parent.FirstVideo) {
+			parent.FirstVideo = item.FirstVideo
+		}
+		if item.LastVideo > parent.LastVideo {
+			parent.LastVideo = item.LastVideo
+		}
+	}
+}
+
+func buildHomeVideo(coll *Collection, ign *ignoreMatcher, dir string, video *FileInfo, files []FileInfo) (clip *Item) {
+	name := video.Name()
+	base := isVideo.FindStringSubmatch(name)[1]
+	fn := path.Join(coll.Directory, dir, name)
+
+	recorded := recordingDate(fn, base)
+	if recorded.IsZero() {
+		recorded = video.Modtime()
+		coll.diag(diagInfo, base, fn,
+			"no recording date, using file modification time")
+	}
+
+	title := base
+	if isCameraName.MatchString(base) || isRecordedDate.MatchString(base) {
+		title = recorded.Format("2006-01-02 15:04")
+	}
+
+	created := video.CreatetimeMS()
+	clip = &Item{
+		Id: idHash("homevideo:" + coll.Name_ + "/" + path.Join(dir, name)),
+		Name: base,
+		Title: title,
+		Year: recorded.Year(),
+		SortName: recorded.Format("2006-01-02 15:04:05"),
+		Recorded: recorded.Format(time.RFC3339),
+		BaseUrl: coll.BaseUrl,
+		Path: escapePath(dir),
+		Video: escapePath(name),
+		FirstVideo: created,
+		LastVideo: created,
+		Type: "homevideo",
+	}
+	clip.Stream = readStrm(fn)
+	clip.addFiles(coll, ign, dir, files, base, true)
+
+	// a plain "clip.jpg" is the thumbnail.
+	if clip.Thumb == "" {
+		for _, f := range files {
+			if s := isImage.FindStringSubmatch(f.Name()); len(s) > 0 && s[1] == base {
+				clip.setArt("thumb", escapePath(f.Name()))
+			}
+		}
+	}
+	return
+}
+
+// Recording date from the mp4 movie header, or from the filename.
+func recordingDate(fn string, base string) (t time.Time) {
+	if f, err := vfsOpen(fn); err == nil {
+		if fi, err := f.Stat(); err == nil {
+			t, _, _ = mp4MovieHeader(f, fi.Size())
+		}
+		f.Close()
+	}
+	// some cameras write zero, or a date that was never set.
+	if t.Year() < 1995 || t.After(time.Now().Add(24 * time.Hour)) {
+		t = time.Time{}
+	}
+	if !t.IsZero() {
+		return
+	}
+
+	s := isRecordedDate.FindStringSubmatch(base)
+	if len(s) == 0 {
+		return
+	}
+	hh, mm, ss := 0, 0, 0
+	if s[4] != "" {
+		hh, mm, ss = parseInt(s[4]), parseInt(s[5]), parseInt(s[6])
+	}
+	t = time.Date(parseInt(s[1]), time.Month(parseInt(s[2])), parseInt(s[3]),
+		hh, mm, ss, 0, time.Local)
+	if t.Month() != time.Month(parseInt(s[2])) {
+		// no such date, like the 31st of february.
+		t = time.Time{}
+	}
+	return
+}
@@ -37,7 +37,7 @@ func idHash(name string) string {
 		} else if m < 62 {
 			c = m + 97 - 36
 		}
-		id += string(c)
+		id += string(rune(c))
 	}
 
 	return id
@@ -0,0 +1,199 @@
+// Ignore rules.
+//
+// Files and directories are ignored if their name starts with "." or "+ ",
+// or if they match an `ignore' pattern in the collection config or
+// in a .notflixignore file. Those work like .gitignore files: they can
+// be put in the collection directory and in any directory below it,
+// and their patterns are relative to the directory the file is in.
+//
+//	# comment
+//	*.sample.mp4	ignore these files, in any directory
+//	/Extras		ignore Extras, only in this directory
+//	trailers/	ignore directories called trailers
+//	**/tmp/**	ignore everything below any `tmp' directory
+//	!keep.mp4	but do not ignore keep.mp4
+package main
+
+import (
+	"bufio"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const ignoreFile = ".notflixignore"
+
+type ignoreRule struct {
+	re		*regexp.Regexp
+	negate		bool
+	dirOnly		bool
+}
+
+// The rules of one directory, plus the ones of its parents.
+type ignoreMatcher struct {
+	parent		*ignoreMatcher
+	dir		string
+	rules		[]ignoreRule
+}
+
+// Translate a gitignore style pattern to a regexp.
+func ignorePattern(pattern string) (rule ignoreRule, ok bool) {
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return
+	}
+
+	// a slash anywhere but at the end anchors the pattern.
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var re strings.Builder
+	if anchored {
+		re.WriteString("^")
+	} else {
+		re.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 0 {
+				re.WriteString(`\[`)
+				break
+			}
+			class := pattern[i+1:i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += j
+		case c == '\\' && i + 1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i:i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	var err error
+	rule.re, err = regexp.Compile(re.String())
+	ok = err == nil
+	return
+}
+
+func parseIgnoreRules(lines []string) (rules []ignoreRule) {
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if rule, ok := ignorePattern(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return
+}
+
+func readIgnoreFile(dir string) (lines []string) {
+	fh, err := vfsOpen(path.Join(dir, ignoreFile))
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return
+}
+
+// Matcher for the collection directory: the `ignore' patterns from
+// the config, and the .notflixignore file in the collection directory.
+func newIgnoreMatcher(coll *Collection) (m *ignoreMatcher) {
+	m = &ignoreMatcher{
+		dir: path.Clean(coll.Directory),
+		rules: parseIgnoreRules(coll.Ignore),
+	}
+	m.rules = append(m.rules,
+			parseIgnoreRules(readIgnoreFile(coll.Directory))...)
+	return
+}
+
+// Matcher for a subdirectory.
+func (m *ignoreMatcher) sub(dir string) *ignoreMatcher {
+	if dir == m.dir {
+		return m
+	}
+	rules := parseIgnoreRules(readIgnoreFile(dir))
+	if len(rules) == 0 {
+		return m
+	}
+	return &ignoreMatcher{
+		parent: m,
+		dir: dir,
+		rules: rules,
+	}
+}
+
+// Check if the file `name' in directory `dir' is ignored.
+// isDir is only called if a rule needs to know.
+func (m *ignoreMatcher) ignored(dir string, name string, isDir func() bool) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "+ ") {
+		return true
+	}
+
+	// parent rules first, the last rule that matches wins.
+	var levels []*ignoreMatcher
+	for l := m; l != nil; l = l.parent {
+		levels = append([]*ignoreMatcher{ l }, levels...)
+	}
+	full := path.Join(dir, name)
+	ignored := false
+	for _, l := range levels {
+		rel := strings.TrimPrefix(full, l.dir + "/")
+		if rel == full {
+			continue
+		}
+		for _, r := range l.rules {
+			if r.negate == !ignored || !r.re.MatchString(rel) {
+				continue
+			}
+			if r.dirOnly && !isDir() {
+				continue
+			}
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// Remove the ignored entries of directory `dir'.
+func (m *ignoreMatcher) filter(dir string, fi []FileInfo) (res []FileInfo) {
+	res = make([]FileInfo, 0, len(fi))
+	for i := range fi {
+		f := &fi[i]
+		if !m.ignored(dir, f.Name(), f.IsDir) {
+			res = append(res, *f)
+		}
+	}
+	return
+}
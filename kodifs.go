@@ -5,22 +5,41 @@ package main
 
 import (
 //	"fmt"
+	"io"
 	"path"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"net/url"
 )
 
-var isVideo = regexp.MustCompile(`^(.*)\.(divx|mov|mp4|MP4|m4u|m4v)$`)
+var isVideo = regexp.MustCompile(`^(.*)\.(divx|mov|mp4|MP4|m4u|m4v|strm)$`)
+var isExtraVideo = regexp.MustCompile(`(?i)(^|[-._ ])(sample|trailer)\.[a-z0-9]+$`)
 var isImage = regexp.MustCompile(`^(.+)\.(jpg|jpeg|png|tbn)$`)
 var isImageExt = regexp.MustCompile(`^(jpg|jpeg|png|tbn)$`)
 var isSeasonImg = regexp.MustCompile(`^season([0-9]+)-?([a-z]+|)\.(jpg|jpeg|png|tbn)$`)
 var isShowSubdir = regexp.MustCompile(`^S([0-9]+)|Specials([0-9]*)$`)
 var isExt1 = regexp.MustCompile(`^(.*)()\.(png|jpg|jpeg|tbn|nfo|srt)$`)
 var isExt2 = regexp.MustCompile(`^(.*)[.-]([a-z]+)\.(png|jpg|jpeg|tbn|nfo|srt)$`)
-var isYear = regexp.MustCompile(` \(([0-9]+)\)$`)
+
+// Kodi artwork names, and the artwork type they map to.
+var artTypes = map[string]string{
+	"banner":	"banner",
+	"characterart":	"characterart",
+	"clearart":	"clearart",
+	"clearlogo":	"clearlogo",
+	"disc":		"discart",
+	"discart":	"discart",
+	"fanart":	"fanart",
+	"folder":	"folder",
+	"keyart":	"keyart",
+	"landscape":	"landscape",
+	"logo":		"clearlogo",
+	"poster":	"poster",
+	"thumb":	"thumb",
+}
 
 type epMapType struct {
 	eps	*[]Episode
@@ -32,24 +51,191 @@ func escapePath(p string) string {
 	return u.EscapedPath()
 }
 
+// A Kodi .strm file has the URL of the stream in it, on the first
+// line that is not empty and not a #comment or #KODIPROP.
+func readStrm(fn string) (target string) {
+	if !strings.HasSuffix(fn, ".strm") {
+		return
+	}
+	f, err := vfsOpen(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	buf := make([]byte, 65536)
+	n, _ := io.ReadFull(f, buf)
+	for _, line := range strings.Split(string(buf[:n]), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			target = line
+			return
+		}
+	}
+	return
+}
+
+func unescapePath(p string) string {
+	u, err := url.PathUnescape(p)
+	if err != nil {
+		return p
+	}
+	return u
+}
+
+// Directory of an item on disk.
+func itemDir(coll *Collection, item *Item) string {
+	return path.Join(coll.Directory, unescapePath(item.Path))
+}
+
+// Where the NFO file of an item is, or should be.
+func itemNfoPath(coll *Collection, item *Item) string {
+	if item.NfoPath != "" {
+		return item.NfoPath
+	}
+	if item.Type == "show" {
+		return path.Join(itemDir(coll, item), "tvshow.nfo")
+	}
+	if item.Video == "" {
+		return path.Join(itemDir(coll, item), "movie.nfo")
+	}
+	video := unescapePath(item.Video)
+	base := strings.TrimSuffix(video, path.Ext(video))
+	return path.Join(itemDir(coll, item), base + ".nfo")
+}
+
+// Where the NFO file of an episode is, or should be.
+func episodeNfoPath(coll *Collection, show *Item, ep *Episode) string {
+	if ep.NfoPath != "" {
+		return ep.NfoPath
+	}
+	dir := path.Dir(unescapePath(ep.Video))
+	return path.Join(itemDir(coll, show), dir, ep.BaseName + ".nfo")
+}
+
+func isArtType(name string) bool {
+	_, ok := artTypes[name]
+	return ok
+}
+
+// Store an image path in art. Returns false if `name' is not
+// a known artwork type.
+func (art *Art) set(name string, p string) bool {
+	switch artTypes[name] {
+	case "banner":		art.Banner = p
+	case "characterart":	art.Characterart = p
+	case "clearart":	art.Clearart = p
+	case "clearlogo":	art.Clearlogo = p
+	case "discart":		art.Discart = p
+	case "fanart":		art.Fanart = p
+	case "folder":		art.Folder = p
+	case "keyart":		art.Keyart = p
+	case "landscape":	art.Landscape = p
+	case "poster":		art.Poster = p
+	case "thumb":		art.Thumb = p
+	default:
+		return false
+	}
+	return true
+}
+
+// Set artwork of an item. The basic types are also stored in the
+// item itself, as older clients expect them there.
+func (item *Item) setArt(name string, p string) bool {
+	if !isArtType(name) {
+		return false
+	}
+	if item.Art == nil {
+		item.Art = &Art{}
+	}
+	item.Art.set(name, p)
+	switch artTypes[name] {
+	case "banner":	item.Banner = p
+	case "fanart":	item.Fanart = p
+	case "folder":	item.Folder = p
+	case "poster":	item.Poster = p
+	case "thumb":	item.Thumb = p
+	}
+	return true
+}
+
+func (item *Item) setExtrafanart(fanart []string) {
+	if len(fanart) == 0 {
+		return
+	}
+	if item.Art == nil {
+		item.Art = &Art{}
+	}
+	item.Art.Extrafanart = fanart
+}
+
+func (season *Season) setArt(name string, p string) bool {
+	if !isArtType(name) {
+		return false
+	}
+	if season.Art == nil {
+		season.Art = &Art{}
+	}
+	season.Art.set(name, p)
+	switch artTypes[name] {
+	case "banner":	season.Banner = p
+	case "fanart":	season.Fanart = p
+	case "poster":	season.Poster = p
+	}
+	return true
+}
+
+// Find the images in the `extrafanart' subdirectory of `dir'.
+// `rel' is the path of `dir' relative to the item.
+func scanExtrafanart(ign *ignoreMatcher, dir string, rel string) (fanart []string) {
+	d := path.Join(dir, "extrafanart")
+	f, err := OpenDir(d)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, _ := f.Readdir(0)
+	fi = ign.sub(d).filter(d, fi)
+	for _, f := range fi {
+		if isImage.MatchString(f.Name()) {
+			p := path.Join(rel, "extrafanart", f.Name())
+			fanart = append(fanart, escapePath(p))
+		}
+	}
+	sort.Strings(fanart)
+	return
+}
+
 func buildMovies(coll *Collection, pace int) (items []*Item) {
 
 	f, err := OpenDir(coll.Directory)
 	if err != nil {
+		coll.scanError("", coll.Directory, "cannot read collection: %s", err)
 		return
 	}
 	defer f.Close()
 	fi, _ := f.Readdir(0)
+	ign := newIgnoreMatcher(coll)
+	fi = ign.filter(coll.Directory, fi)
 	if len(fi) == 0 {
 		return
 	}
-	for _, f := range fi {
-		name := f.Name()
-		if (len(name) > 0 && name[:1] == ".") ||
-		   (len(name) > 1 && name[:2] == "+ ") {
+	flat := coll.Layout == "flat"
+	var sidecars map[string][]FileInfo
+	if flat {
+		sidecars = flatSidecars(fi)
+	}
+	for i := range fi {
+		name := fi[i].Name()
+		var m *Item
+		switch {
+		case flat && isVideo.MatchString(name):
+			m = buildFlatMovie(coll, ign, sidecars, &fi[i])
+		case flat && !fi[i].IsDir():
+			// belongs to a video, or to nothing.
 			continue
+		default:
+			m = buildMovie(coll, ign, name)
 		}
-		m := buildMovie(coll, name)
 		if m != nil {
 			items = append(items, m)
 		}
@@ -62,41 +248,125 @@ func buildMovies(coll *Collection, pace int) (items []*Item) {
 	return
 }
 
-func buildMovie(coll *Collection, dir string) (movie *Item) {
+func buildMovie(coll *Collection, ign *ignoreMatcher, dir string) (movie *Item) {
 
 	d := path.Join(coll.Directory, dir)
 	f, err := OpenDir(d)
 	if err != nil {
+		if !isNotDirectory(err) {
+			coll.scanError(dir, d, "cannot read directory: %s", err)
+		}
 		return
 	}
 	defer f.Close()
-	fi, _ := f.Readdir(0)
+	fi, err := f.Readdir(0)
+	if err != nil {
+		coll.scanError(dir, d, "cannot read directory: %s", err)
+	}
+	ign = ign.sub(d)
+	fi = ign.filter(d, fi)
 	if len(fi) == 0 {
 		return
 	}
 	mname := path.Base(dir)
 
-	var base, video string
-	var created int64
+	// a sample or trailer is not the movie, unless there is nothing else.
+	var video, extra string
+	var created, extraCreated int64
 	for _, f := range fi {
 		s := isVideo.FindStringSubmatch(f.Name())
-		if len(s) > 0 {
-			ts := f.CreatetimeMS()
-			if ts > 0 {
-				created = ts
-				video = s[0]
-				base = s[1]
-			}
+		if len(s) == 0 {
+			continue
+		}
+		ts := f.CreatetimeMS()
+		if ts <= 0 {
+			continue
+		}
+		if isExtraVideo.MatchString(s[0]) {
+			extra, extraCreated = s[0], ts
+		} else {
+			video, created = s[0], ts
 		}
 	}
 	if video == "" {
+		if disc, ts := findDisc(coll, mname, d, fi); disc != nil {
+			movie = movieFromFiles(coll, ign, dir, mname, fi, "", ts, false)
+			movie.Disc = disc
+			return
+		}
+		video, created = extra, extraCreated
+	}
+	if video == "" {
+		coll.diag(diagWarning, mname, d, "no video file found")
+		return
+	}
+
+	movie = movieFromFiles(coll, ign, dir, mname, fi, video, created, false)
+	return
+}
+
+// Flat layout: the movie is a video file in the collection directory,
+// the files that belong to it have the same basename (see flatSidecars).
+func buildFlatMovie(coll *Collection, ign *ignoreMatcher, sidecars map[string][]FileInfo, video *FileInfo) (movie *Item) {
+	s := isVideo.FindStringSubmatch(video.Name())
+	if len(s) == 0 {
 		return
 	}
+	base := s[1]
+	movie = movieFromFiles(coll, ign, "", base, sidecars[base], video.Name(),
+				video.CreatetimeMS(), true)
+	return
+}
+
+// The files of a flat directory by the basename of the video that
+// they would belong to, like isSidecar, in one pass.
+func flatSidecars(fi []FileInfo) (files map[string][]FileInfo) {
+	files = make(map[string][]FileInfo)
+	for _, f := range fi {
+		s1 := isExt1.FindStringSubmatch(f.Name())
+		if len(s1) > 0 {
+			files[s1[1]] = append(files[s1[1]], f)
+		}
+		s2 := isExt2.FindStringSubmatch(f.Name())
+		if len(s2) > 0 && (len(s1) == 0 || s2[1] != s1[1]) {
+			files[s2[1]] = append(files[s2[1]], f)
+		}
+	}
+	return
+}
+
+// Does `name' belong to the video with basename `base'.
+func isSidecar(name string, base string) bool {
+	s := isExt1.FindStringSubmatch(name)
+	if len(s) > 0 && s[1] == base {
+		return true
+	}
+	s = isExt2.FindStringSubmatch(name)
+	return len(s) > 0 && s[1] == base
+}
 
-	s := isYear.FindStringSubmatch(dir)
-	year := 0
-	if len(s) > 0 {
-		year = parseInt(s[1])
+// Build a movie from the files in `dir', which is relative to
+// the collection directory. In flat mode, `fi' only has the files
+// that belong to this movie. `video' is empty for disc backups.
+func movieFromFiles(coll *Collection, ign *ignoreMatcher, dir string, mname string, fi []FileInfo, video string, created int64, flat bool) (movie *Item) {
+
+	d := path.Join(coll.Directory, dir)
+	base := mname
+	if s := isVideo.FindStringSubmatch(video); len(s) > 0 {
+		base = s[1]
+	}
+
+	// the directory name is usually cleaner than the filename,
+	// but the filename often has the release info.
+	title, year, release := cleanTitle(mname)
+	if year == 0 || release == nil {
+		_, y, r := cleanTitle(base)
+		if year == 0 {
+			year = y
+		}
+		if release == nil {
+			release = r
+		}
 	}
 	if year == 0 && created > 0 {
 		t := time.Unix(created / 1000, 0)
@@ -108,7 +378,9 @@ func buildMovie(coll *Collection, dir string) (movie *Item) {
 
 	movie = &Item{
 		Name: mname,
+		Title: title,
 		Year: year,
+		Release: release,
 		BaseUrl: coll.BaseUrl,
 		Path: escapePath(dir),
 		Video: escapePath(video),
@@ -116,17 +388,47 @@ func buildMovie(coll *Collection, dir string) (movie *Item) {
 		LastVideo: created,
 		Type: `movie`,
 	}
+	movie.Stream = readStrm(path.Join(d, video))
+	if strings.HasSuffix(video, ".strm") && movie.Stream == "" {
+		coll.diag(diagWarning, mname, path.Join(d, video), "empty .strm file")
+	}
+
+	movie.addFiles(coll, ign, dir, fi, base, flat)
+
+	if movie.Poster == "" {
+		coll.diag(diagWarning, mname, d, "no poster")
+	}
+
+	dbLoadItem(coll, movie)
+
+	return
+}
+
+// Add the images, subtitles and NFO file in `fi' to a movie.
+// In flat mode, only the files named after the video are used.
+func (movie *Item) addFiles(coll *Collection, ign *ignoreMatcher, dir string, fi []FileInfo, base string, flat bool) {
+	d := path.Join(coll.Directory, dir)
+	mname := movie.Name
+	video := unescapePath(movie.Video)
 
 	for _, f := range fi {
 		name := f.Name()
 
+		if name == "extrafanart" && !flat {
+			movie.setExtrafanart(scanExtrafanart(ign, d, ""))
+			continue
+		}
+
 		var aux string;
 		var ext string;
+		matched := false
 		s := isExt1.FindStringSubmatch(name)
 		if len(s) > 0 {
 			ext = s[3]
 			if s[1] != base {
 				aux = s[1]
+			} else {
+				matched = true
 			}
 		}
 		if len(s) == 0 || s[1] != base {
@@ -134,27 +436,31 @@ func buildMovie(coll *Collection, dir string) (movie *Item) {
 			if len(s) > 0 && s[1] == base {
 				aux = s[2]
 				ext = s[3]
+				matched = true
 			}
 		}
 		if ext == "" {
 			continue
 		}
+		if flat && !matched {
+			continue
+		}
 		p := escapePath(name)
 
 		if isImage.MatchString(name) {
 			if ext == "tbn" && aux == "" {
 				aux = "poster"
 			}
-			switch aux {
-			case `banner`:	movie.Banner = p
-			case `fanart`:	movie.Fanart = p
-			case `folder`:	movie.Folder = p
-			case `poster`:	movie.Poster = p
-			}
+			movie.setArt(aux, p)
 			continue
 		}
 
 		if ext == "srt" {
+			if !matched {
+				coll.diag(diagWarning, mname, path.Join(d, name),
+					"subtitle does not match video %s", video)
+				continue
+			}
 			if aux == "" || aux == "und" {
 				aux = "zz"
 			}
@@ -183,30 +489,25 @@ func buildMovie(coll *Collection, dir string) (movie *Item) {
 	}
 
 	copySrtVttSubs(movie.SrtSubs, &movie.VttSubs)
-
-	dbLoadItem(coll, movie)
-
-	return
 }
 
 func buildShows(coll *Collection, pace int) (items []*Item) {
 
 	f, err := OpenDir(coll.Directory)
 	if err != nil {
+		coll.scanError("", coll.Directory, "cannot read collection: %s", err)
 		return
 	}
 	defer f.Close()
 	fi, _ := f.Readdir(0)
+	ign := newIgnoreMatcher(coll)
+	fi = ign.filter(coll.Directory, fi)
 	if len(fi) == 0 {
 		return
 	}
 	for _, f := range fi {
 		name := f.Name()
-		if (len(name) > 0 && name[:1] == ".") ||
-		   (len(name) > 1 && name[:2] == "+ ") {
-			continue
-		}
-		m := buildShow(coll, name)
+		m := buildShow(coll, ign, name)
 		if m != nil {
 			items = append(items, m)
 		}
@@ -259,15 +560,23 @@ func epMatch(epMap map[string]epMapType, s []string) (ep *Episode, aux, ext stri
 	return
 }
 
-func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
+func showScanDir(coll *Collection, ign *ignoreMatcher, baseDir string, dir string, seasonHint int, show *Item) {
 
 	d := path.Join(baseDir, dir)
 	f, err := OpenDir(d)
 	if err != nil {
+		if !isNotDirectory(err) {
+			coll.scanError(show.Name, d, "cannot read directory: %s", err)
+		}
 		return
 	}
 	defer f.Close()
-	fi, _ := f.Readdir(0)
+	fi, err := f.Readdir(0)
+	if err != nil {
+		coll.scanError(show.Name, d, "cannot read directory: %s", err)
+	}
+	ign = ign.sub(d)
+	fi = ign.filter(d, fi)
 	if len(fi) == 0 {
 		return
 	}
@@ -285,7 +594,7 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 			s := isShowSubdir.FindStringSubmatch(fn)
 			if len(s) > 0 {
 				sn := parseInt(s[1])
-				showScanDir(d, fn, sn, show)
+				showScanDir(coll, ign, d, fn, sn, show)
 				continue
 			}
 
@@ -295,6 +604,12 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 				continue
 			}
 
+			// extra backdrops.
+			if fn == "extrafanart" {
+				show.setExtrafanart(scanExtrafanart(ign, d, ""))
+				continue
+			}
+
 			// other images.
 			s = isImage.FindStringSubmatch(fn)
 			if len(s) > 0 {
@@ -302,12 +617,12 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 				switch (s[1]) {
 				case "season-all-banner":
 					show.SeasonAllBanner = p
+				case "season-all-fanart":
+					show.SeasonAllFanart = p
 				case "season-all-poster":
 					show.SeasonAllPoster = p
-				case "banner": show.Banner = p
-				case "fanart": show.Fanart = p
-				case "folder": show.Folder = p
-				case "poster": show.Poster = p
+				default:
+					show.setArt(s[1], p)
 				}
 			}
 		}
@@ -317,18 +632,10 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 		if seasonHint >= 0 {
 			s := isImage.FindStringSubmatch(fn)
 			c := false
-			if len(s) > 0 {
+			if len(s) > 0 && isArtType(s[1]) {
 				p := escapePath(path.Join(dir, fn))
-				switch (s[1]) {
-				case "banner":
-					season := getSeason(show, seasonHint)
-					season.Banner = p
-					c = true
-				case "poster":
-					season := getSeason(show, seasonHint)
-					season.Poster = p
-					c = true
-				}
+				season := getSeason(show, seasonHint)
+				c = season.setArt(s[1], p)
 			}
 			if c {
 				continue
@@ -341,14 +648,9 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 			sn := parseInt(s[1])
 			season := getSeason(show, sn)
 			p := escapePath(path.Join(dir, fn))
-			switch s[2] {
-			case "poster":
-				season.Poster = p
-			case "banner":
-				season.Banner = p
-			default:
+			if !season.setArt(s[2], p) {
 				// probably a poster.
-				season.Poster = p
+				season.setArt("poster", p)
 			}
 			continue
 		}
@@ -361,7 +663,8 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 				BaseName: s[1],
 			}
 			ep.VideoTS = f.CreatetimeMS()
-			if parseEpisodeName(s[1], seasonHint, &ep) {
+			ep.Stream = readStrm(path.Join(d, fn))
+			if parseEpisodeName(coll, s[1], seasonHint, &ep) {
 				season := getSeason(show, ep.SeasonNo)
 				season.Episodes =
 					append(season.Episodes, ep)
@@ -370,6 +673,9 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 					eps: &season.Episodes,
 					idx: epIndex,
 				}
+			} else {
+				coll.diag(diagWarning, show.Name, path.Join(d, fn),
+					"cannot find the episode number")
 			}
 		}
 	}
@@ -385,6 +691,10 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 			ep, aux, ext = epMatch(epMap, s)
 		}
 		if ep == nil {
+			if strings.HasSuffix(name, ".srt") {
+				coll.diag(diagWarning, show.Name, path.Join(d, name),
+					"subtitle does not match any episode")
+			}
 			continue
 		}
 		p := escapePath(path.Join(dir, name))
@@ -429,16 +739,20 @@ func showScanDir(baseDir string, dir string, seasonHint int, show *Item) {
 	}
 }
 
-func buildShow(coll *Collection, dir string) (show *Item) {
+func buildShow(coll *Collection, ign *ignoreMatcher, dir string) (show *Item) {
 
+	title, year, _ := cleanTitle(path.Base(dir))
 	item := &Item{
 		Name: path.Base(dir),
+		Title: title,
 		BaseUrl: coll.BaseUrl,
 		Path: escapePath(dir),
 		Type: `show`,
 	}
 	d := path.Join(coll.Directory, dir)
-	showScanDir(d, "", -1, item)
+	showScanDir(coll, ign, d, "", -1, item)
+	mapAbsoluteEpisodes(coll, d, item)
+	numberDateEpisodes(item)
 
 	for i := range item.Seasons {
 		s := &(item.Seasons[i])
@@ -486,12 +800,16 @@ func buildShow(coll *Collection, dir string) (show *Item) {
 	}
 
 	if show == nil {
+		coll.diag(diagWarning, item.Name, d, "not a show: no episodes, " +
+			"and no tvshow.nfo with an image")
 		return
 	}
+	if item.Poster == "" {
+		coll.diag(diagWarning, item.Name, d, "no poster")
+	}
 
 	// guess the year in case it's not in the NFO file.
-	year := 0
-	if item.FirstVideo > 0 {
+	if year == 0 && item.FirstVideo > 0 {
 		t := time.Unix(item.FirstVideo / 1000, 0)
 		year = t.Year()
 	}
@@ -501,10 +819,83 @@ func buildShow(coll *Collection, dir string) (show *Item) {
 	item.Year = year
 
 	dbLoadItem(coll, item)
+	item.setEpisodeIds()
 
 	return
 }
 
+// Date-based shows (daily shows, news) have the year as the season.
+// Number the episodes of each year in order of air date. Only if all
+// episodes are dated: in a show with a few dated specials, those keep
+// the date as their number.
+func numberDateEpisodes(show *Item) {
+	dated, total := 0, 0
+	for _, s := range show.Seasons {
+		for _, ep := range s.Episodes {
+			if ep.Video == "" {
+				continue
+			}
+			total++
+			if ep.AirDate != "" {
+				dated++
+			}
+		}
+	}
+	if dated == 0 || dated != total {
+		return
+	}
+	show.EpisodeOrder = "date"
+
+	for i := range show.Seasons {
+		eps := show.Seasons[i].Episodes
+		sort.SliceStable(eps, func(a, b int) bool {
+			if eps[a].AirDate != eps[b].AirDate {
+				return eps[a].AirDate < eps[b].AirDate
+			}
+			return eps[a].BaseName < eps[b].BaseName
+		})
+		n := 0
+		for e := range eps {
+			if eps[e].Video != "" {
+				n++
+				eps[e].EpisodeNo = n
+			}
+		}
+	}
+}
+
+// Seasons and episodes get ids made from the id of the show and their
+// numbers, so that they stay the same across scans and renames. Dated
+// episodes go by air date, as their numbers can shift. Two
+// versions of the same episode are told apart by their filename.
+func (show *Item) setEpisodeIds() {
+	key := func(ep *Episode) string {
+		if ep.AirDate != "" {
+			return show.Id + "/date/" + ep.AirDate
+		}
+		return show.Id + "/episode/" + strconv.Itoa(ep.SeasonNo) +
+			"/" + strconv.Itoa(ep.EpisodeNo)
+	}
+	count := make(map[string]int)
+	for _, s := range show.Seasons {
+		for ei := range s.Episodes {
+			count[key(&s.Episodes[ei])]++
+		}
+	}
+	for si := range show.Seasons {
+		s := &(show.Seasons[si])
+		s.Id = idHash(show.Id + "/season/" + strconv.Itoa(s.SeasonNo))
+		for ei := range s.Episodes {
+			ep := &(s.Episodes[ei])
+			k := key(ep)
+			if count[k] > 1 {
+				k += "/" + ep.BaseName
+			}
+			ep.Id = idHash(k)
+		}
+	}
+}
+
 func copySrtVttSubs(srt []Subs, vtt *[]Subs) {
 	for i := range srt {
 		sub := Subs{ Lang: srt[i].Lang }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNumberDateEpisodes(t *testing.T) {
+	dated := func(date string, name string) Episode {
+		ep := Episode{ Video: name + ".mp4", BaseName: name }
+		ep.AirDate = date
+		ep.SeasonNo = parseInt(date[0:4])
+		ep.EpisodeNo = parseInt(date[0:4] + date[5:7] + date[8:10])
+		return ep
+	}
+
+	// all dated: numbered in order of air date.
+	show := &Item{ Id: "daily", Seasons: []Season{{
+		SeasonNo: 2015,
+		Episodes: []Episode{
+			dated("2015-03-09", "b"),
+			dated("2015-03-08", "a"),
+		},
+	}}}
+	numberDateEpisodes(show)
+	show.setEpisodeIds()
+	eps := show.Seasons[0].Episodes
+	if show.EpisodeOrder != "date" || eps[0].AirDate != "2015-03-08" ||
+	   eps[0].EpisodeNo != 1 || eps[1].EpisodeNo != 2 {
+		t.Errorf("all dated: order %q, %+v", show.EpisodeOrder, eps)
+	}
+	if eps[0].Id != idHash("daily/date/2015-03-08") {
+		t.Errorf("all dated: id not by air date")
+	}
+
+	// a dated special in a numbered show keeps its number.
+	special := dated("2015-12-25", "xmas")
+	show = &Item{ Id: "show", Seasons: []Season{
+		{ SeasonNo: 1, Episodes: []Episode{
+			{ Video: "e1.mp4", BaseName: "e1", SeasonNo: 1, EpisodeNo: 1 },
+		}},
+		{ SeasonNo: 2015, Episodes: []Episode{ special }},
+	}}
+	numberDateEpisodes(show)
+	show.setEpisodeIds()
+	ep := show.Seasons[1].Episodes[0]
+	if show.EpisodeOrder != "" || ep.EpisodeNo != special.EpisodeNo {
+		t.Errorf("mixed: order %q, episode %d", show.EpisodeOrder, ep.EpisodeNo)
+	}
+	if ep.Id != idHash("show/date/2015-12-25") {
+		t.Errorf("mixed: id not by air date")
+	}
+}
+
+func TestFlatMovies(t *testing.T) {
+	testDb(t)
+	saved := config.Collections
+	defer func() { config.Collections = saved }()
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"Alien (1979).mp4":		"video",
+		"Alien (1979).nfo":		"<movie><title>Alien</title></movie>",
+		"Alien (1979)-poster.jpg":	"jpeg",
+		"Heat (1995).mp4":		"video",
+		"Heat (1995).en.srt":		"subs",
+		"orphan.nfo":			"<movie></movie>",
+	} {
+		os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+	}
+	config.Collections = []Collection{{
+		Name_: "test",
+		Type: "movies",
+		Directory: dir,
+		Layout: "flat",
+	}}
+	c := &config.Collections[0]
+	updateCollection(c, 1, 0)
+	byName := map[string]*Item{}
+	for _, item := range c.Items {
+		byName[item.Name] = item
+	}
+	alien, heat := byName["Alien (1979)"], byName["Heat (1995)"]
+	if len(c.Items) != 2 || alien == nil || heat == nil {
+		t.Fatalf("got %d movies", len(c.Items))
+	}
+	if alien.Poster == "" || alien.NfoPath == "" || len(heat.SrtSubs) != 1 {
+		t.Errorf("sidecars: poster %q nfo %q subs %v",
+			alien.Poster, alien.NfoPath, heat.SrtSubs)
+	}
+
+	// renamed: keeps its id.
+	os.Rename(filepath.Join(dir, "Alien (1979).mp4"), filepath.Join(dir, "Alien.1979.mp4"))
+	os.Rename(filepath.Join(dir, "Alien (1979).nfo"), filepath.Join(dir, "Alien.1979.nfo"))
+	updateCollection(c, 1, 0)
+	found := false
+	for _, item := range c.Items {
+		if item.Name == "Alien.1979" {
+			found = true
+			if item.Id != alien.Id {
+				t.Errorf("renamed movie got a new id")
+			}
+		}
+	}
+	if !found {
+		t.Errorf("renamed movie not found")
+	}
+}
+
+// Samples and trailers are not the movie.
+func TestMovieExtras(t *testing.T) {
+	testDb(t)
+	coll := &Collection{
+		Name_: "test",
+		Type: "movies",
+		Directory: testMountMem(t, map[string]string{
+			"Disc (2000)/Disc (2000).iso":		"iso",
+			"Disc (2000)/Disc (2000)-trailer.mp4":	"video",
+			"Movie (2001)/Movie (2001).mp4":	"video",
+			"Movie (2001)/sample.mp4":		"video",
+			"Trailer (2002)/Trailer (2002)-trailer.mp4": "video",
+		}),
+	}
+	byName := map[string]*Item{}
+	for _, item := range buildMovies(coll, 0) {
+		byName[item.Name] = item
+	}
+	if m := byName["Disc (2000)"]; m == nil || m.Disc == nil || m.Video != "" {
+		t.Errorf("disc: %+v", m)
+	}
+	if m := byName["Movie (2001)"]; m == nil || m.Video != "Movie%20%282001%29.mp4" {
+		t.Errorf("movie: %+v", m)
+	}
+	if m := byName["Trailer (2002)"]; m == nil || m.Video == "" {
+		t.Errorf("only a trailer: %+v", m)
+	}
+}
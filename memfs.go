@@ -0,0 +1,204 @@
+// An in-memory directory tree, implementing fs.FS.
+//
+// The contents of a file can be a byte slice, or a section of
+// another file. That is how zip and tar archives are mounted:
+// the tree is built from the archive index, the data stays in
+// the archive. It is also handy for tests.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type MemFS struct {
+	nodes		map[string]*memNode
+}
+
+type memNode struct {
+	name		string
+	mode		fs.FileMode
+	modtime		time.Time
+	createtime	time.Time
+	size		int64
+	data		io.ReaderAt
+	children	[]string
+
+	// compressed archive members are unpacked for every open file,
+	// so that the data goes away when the file is closed.
+	load		func() ([]byte, error)
+}
+
+type memFile struct {
+	node		*memNode
+	r		*io.SectionReader
+	dirpos		int
+	fsys		*MemFS
+	path		string
+}
+
+func NewMemFS() (m *MemFS) {
+	m = &MemFS{ nodes: map[string]*memNode{} }
+	m.nodes["."] = &memNode{ name: ".", mode: fs.ModeDir | 0755 }
+	return
+}
+
+// Add a file, and the directories above it.
+func (m *MemFS) AddFile(name string, data []byte, modtime time.Time) {
+	m.addNode(name, &memNode{
+		mode: 0644,
+		modtime: modtime,
+		createtime: modtime,
+		size: int64(len(data)),
+		data: bytes.NewReader(data),
+	})
+}
+
+func (m *MemFS) addNode(name string, n *memNode) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if name == "." {
+		return
+	}
+	n.name = path.Base(name)
+	if old, ok := m.nodes[name]; ok && old.mode.IsDir() && n.mode.IsDir() {
+		old.modtime = n.modtime
+		old.createtime = n.createtime
+		return
+	}
+	dir := path.Dir(name)
+	if _, ok := m.nodes[dir]; !ok {
+		m.addNode(dir, &memNode{ mode: fs.ModeDir | 0755, modtime: n.modtime })
+	}
+	if _, ok := m.nodes[name]; !ok {
+		p := m.nodes[dir]
+		p.children = append(p.children, n.name)
+	}
+	m.nodes[name] = n
+}
+
+func (m *MemFS) lookup(op string, name string) (n *memNode, err error) {
+	if !fs.ValidPath(name) {
+		err = &fs.PathError{ Op: op, Path: name, Err: fs.ErrInvalid }
+		return
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		err = &fs.PathError{ Op: op, Path: name, Err: fs.ErrNotExist }
+	}
+	return
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	n, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f := &memFile{ node: n, fsys: m, path: name }
+	if !n.mode.IsDir() {
+		data := n.data
+		if n.load != nil {
+			b, err := n.load()
+			if err != nil {
+				return nil, &fs.PathError{ Op: "open", Path: name, Err: err }
+			}
+			data = bytes.NewReader(b)
+		}
+		// data that is slow to read (S3 objects) is buffered per open file.
+		if o, ok := data.(interface{ newReader() io.ReaderAt }); ok {
+			data = o.newReader()
+		}
+		f.r = io.NewSectionReader(data, 0, n.size)
+	}
+	return f, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (m *MemFS) ReadDir(name string) (de []fs.DirEntry, err error) {
+	n, err := m.lookup("readdir", name)
+	if err != nil {
+		return
+	}
+	if !n.mode.IsDir() {
+		err = &fs.PathError{ Op: "readdir", Path: name, Err: errors.New("not a directory") }
+		return
+	}
+	de = m.entries(name, n)
+	return
+}
+
+func (m *MemFS) entries(dir string, n *memNode) (de []fs.DirEntry) {
+	names := append([]string{}, n.children...)
+	sort.Strings(names)
+	for _, c := range names {
+		de = append(de, fs.FileInfoToDirEntry(m.nodes[path.Join(dir, c)]))
+	}
+	return
+}
+
+// memNode is its own fs.FileInfo.
+func (n *memNode) Name() string { return n.name }
+func (n *memNode) Size() int64 { return n.size }
+func (n *memNode) Mode() fs.FileMode { return n.mode }
+func (n *memNode) ModTime() time.Time { return n.modtime }
+func (n *memNode) IsDir() bool { return n.mode.IsDir() }
+func (n *memNode) Sys() interface{} { return n }
+func (n *memNode) Createtime() time.Time { return n.createtime }
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.node, nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.r == nil {
+		return 0, &fs.PathError{ Op: "read", Path: f.path, Err: fs.ErrInvalid }
+	}
+	return f.r.Read(b)
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.r == nil {
+		return 0, &fs.PathError{ Op: "read", Path: f.path, Err: fs.ErrInvalid }
+	}
+	return f.r.ReadAt(b, off)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.r == nil {
+		return 0, &fs.PathError{ Op: "seek", Path: f.path, Err: fs.ErrInvalid }
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *memFile) ReadDir(n int) (de []fs.DirEntry, err error) {
+	all := f.fsys.entries(f.path, f.node)
+	de = all[f.dirpos:]
+	if n > 0 {
+		if len(de) == 0 {
+			err = io.EOF
+			return
+		}
+		if len(de) > n {
+			de = de[:n]
+		}
+	}
+	f.dirpos += len(de)
+	return
+}
+
+func (f *memFile) Close() error {
+	f.r = nil
+	return nil
+}
@@ -0,0 +1,434 @@
+// Versioned schema migrations.
+//
+// The schema_version table has a row for every migration that was
+// applied. At startup the missing ones are applied in order, each
+// in its own transaction. To change the schema, add a migration at
+// the end of the list, never change one that was released.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type migration struct {
+	version		int
+	name		string
+	up		func(tx *sqlx.Tx) error
+}
+
+var migrations = []migration{
+	{ 1, "items table", migrateItems },
+	{ 2, "title and release", migrateTitle },
+	{ 3, "normalized schema", migrateNormalized },
+	{ 4, "item identity", migrateIdentity },
+	{ 5, "tombstones", migrateTombstones },
+	{ 6, "season and episode ids", migrateEpisodeIds },
+}
+
+// Apply the migrations that the database does not have yet.
+func dbMigrate() (err error) {
+	_, err = dbHandle.Exec(`CREATE TABLE IF NOT EXISTS schema_version(
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return
+	}
+	version, err := dbSchemaVersion()
+	if err != nil {
+		return
+	}
+	latest := migrations[len(migrations) - 1].version
+	if version > latest {
+		err = fmt.Errorf("database schema version %d is newer than " +
+			"this server knows (%d), refusing to start", version, latest)
+		return
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		err = dbApply(m)
+		if err != nil {
+			err = fmt.Errorf("migration %d (%s): %s", m.version, m.name, err)
+			return
+		}
+	}
+	return
+}
+
+func dbApply(m migration) (err error) {
+	tx, err := dbHandle.Beginx()
+	if err != nil {
+		return
+	}
+	err = m.up(tx)
+	if err == nil {
+		err = setSchemaVersion(tx, m)
+	}
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	return tx.Commit()
+}
+
+func setSchemaVersion(tx *sqlx.Tx, m migration) (err error) {
+	_, err = tx.Exec(`INSERT INTO schema_version(version, name, applied) ` +
+		`VALUES (?, ?, ?)`, m.version, m.name, time.Now().Unix())
+	return
+}
+
+// Current version. Databases from before schema_version are
+// recognized by what their items table looks like.
+func dbSchemaVersion() (version int, err error) {
+	err = dbHandle.Get(&version, "SELECT coalesce(max(version), 0) FROM schema_version")
+	if err != nil || version > 0 {
+		return
+	}
+	var cols []struct {
+		Name		string
+	}
+	err = dbHandle.Select(&cols, "SELECT name FROM pragma_table_info('items')")
+	if err != nil || len(cols) == 0 {
+		return
+	}
+	have := make(map[string]bool)
+	for _, c := range cols {
+		have[c.Name] = true
+	}
+	tx, err := dbHandle.Beginx()
+	if err != nil {
+		return
+	}
+	// the first items table had the genres in a string, and maybe
+	// the title and release already (see migrateTitle).
+	version = 1
+	if !have["genre"] {
+		version = 3
+	}
+	for _, m := range migrations[:version] {
+		if err == nil {
+			err = setSchemaVersion(tx, m)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// Version 1: one table with the NFO data of movies and shows.
+func migrateItems(tx *sqlx.Tx) (err error) {
+	_, err = tx.Exec(`CREATE TABLE items(
+		id TEXT NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		votes INTEGER,
+		year INTEGER,
+		genre TEXT NOT NULL,
+		rating REAL,
+		nfotime INTEGER NOT NULL,
+		firstvideo INTEGER NOT NULL,
+		lastvideo INTEGER NOT NULL
+	)`)
+	if err == nil {
+		_, err = tx.Exec("CREATE INDEX items_name_idx ON items (name)")
+	}
+	return
+}
+
+// Version 2: the title and release info from the directory name.
+// Databases from before schema_version can have them already.
+func migrateTitle(tx *sqlx.Tx) (err error) {
+	var cols []string
+	err = tx.Select(&cols, "SELECT name FROM pragma_table_info('items')")
+	have := make(map[string]bool)
+	for _, c := range cols {
+		have[c] = true
+	}
+	for _, c := range []string{ "title", "release" } {
+		if err != nil || have[c] {
+			continue
+		}
+		_, err = tx.Exec("ALTER TABLE items ADD COLUMN " + c +
+					" TEXT NOT NULL DEFAULT ''")
+	}
+	return
+}
+
+// Version 3. Items are movies, shows, folders etc. from all
+// collections, the rest hangs off them and goes when they go.
+var schemaV3 = []string{
+	`CREATE TABLE IF NOT EXISTS items(
+		id TEXT NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		collection TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL DEFAULT '',
+		path TEXT NOT NULL DEFAULT '',
+		parent TEXT REFERENCES items(id)
+			ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED,
+		title TEXT NOT NULL DEFAULT '',
+		sortname TEXT NOT NULL DEFAULT '',
+		release TEXT NOT NULL DEFAULT '',
+		votes INTEGER NOT NULL DEFAULT 0,
+		year INTEGER NOT NULL DEFAULT 0,
+		rating REAL NOT NULL DEFAULT 0,
+		nfotime INTEGER NOT NULL DEFAULT 0,
+		firstvideo INTEGER NOT NULL DEFAULT 0,
+		lastvideo INTEGER NOT NULL DEFAULT 0,
+		-- nfotime when the people were read from the NFO.
+		peopletime INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS items_name_idx ON items(name)`,
+	`CREATE INDEX IF NOT EXISTS items_collection_idx ON items(collection, type)`,
+	`CREATE INDEX IF NOT EXISTS items_parent_idx ON items(parent)`,
+
+	`CREATE TABLE IF NOT EXISTS seasons(
+		id INTEGER PRIMARY KEY,
+		item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		seasonno INTEGER NOT NULL,
+		UNIQUE(item_id, seasonno)
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS episodes(
+		id INTEGER PRIMARY KEY,
+		item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		season_id INTEGER NOT NULL REFERENCES seasons(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		seasonno INTEGER NOT NULL,
+		episodeno INTEGER NOT NULL,
+		lastepisodeno INTEGER NOT NULL DEFAULT 0,
+		absoluteno INTEGER NOT NULL DEFAULT 0,
+		airdate TEXT NOT NULL DEFAULT '',
+		double INTEGER NOT NULL DEFAULT 0,
+		sortname TEXT NOT NULL DEFAULT '',
+		nfotime INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS episodes_item_idx ON episodes(item_id, seasonno, episodeno)`,
+	`CREATE INDEX IF NOT EXISTS episodes_season_idx ON episodes(season_id)`,
+	`CREATE INDEX IF NOT EXISTS episodes_airdate_idx ON episodes(airdate)`,
+
+	// video, nfo, disc and stream files of items and episodes.
+	`CREATE TABLE IF NOT EXISTS files(
+		id INTEGER PRIMARY KEY,
+		item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		episode_id INTEGER REFERENCES episodes(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		path TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS files_item_idx ON files(item_id)`,
+	`CREATE INDEX IF NOT EXISTS files_episode_idx ON files(episode_id)`,
+
+	`CREATE TABLE IF NOT EXISTS subtitles(
+		id INTEGER PRIMARY KEY,
+		file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+		lang TEXT NOT NULL,
+		format TEXT NOT NULL,
+		path TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS subtitles_file_idx ON subtitles(file_id)`,
+
+	`CREATE TABLE IF NOT EXISTS images(
+		id INTEGER PRIMARY KEY,
+		item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		season_id INTEGER REFERENCES seasons(id) ON DELETE CASCADE,
+		episode_id INTEGER REFERENCES episodes(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		path TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS images_item_idx ON images(item_id)`,
+
+	`CREATE TABLE IF NOT EXISTS genres(
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS item_genres(
+		item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		genre_id INTEGER NOT NULL REFERENCES genres(id) ON DELETE CASCADE,
+		PRIMARY KEY(item_id, genre_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS item_genres_genre_idx ON item_genres(genre_id)`,
+
+	`CREATE TABLE IF NOT EXISTS people(
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`,
+	// role is actor, director or writer.
+	`CREATE TABLE IF NOT EXISTS item_people(
+		item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+		person_id INTEGER NOT NULL REFERENCES people(id) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		character TEXT NOT NULL DEFAULT '',
+		ord INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS item_people_item_idx ON item_people(item_id)`,
+	`CREATE INDEX IF NOT EXISTS item_people_person_idx ON item_people(person_id)`,
+
+	// snapshots of the scanned collections, see snapshot.go.
+	`CREATE TABLE IF NOT EXISTS snapshots(
+		collection TEXT NOT NULL PRIMARY KEY,
+		scanned INTEGER NOT NULL,
+		data BLOB NOT NULL
+	)`,
+}
+
+// Version 3: the tables above. The genres of version 1 were a comma
+// separated string, they move to item_genres.
+func migrateNormalized(tx *sqlx.Tx) (err error) {
+	var genres []struct {
+		Id	string
+		Genre	string
+	}
+	err = tx.Select(&genres, "SELECT id, genre FROM items")
+	if err == nil {
+		_, err = tx.Exec("DROP INDEX IF EXISTS items_name_idx")
+	}
+	if err == nil {
+		_, err = tx.Exec("ALTER TABLE items RENAME TO items_old")
+	}
+	for _, stmt := range schemaV3 {
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(stmt)
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO items(id, name, title, release, ` +
+		`	votes, year, rating, nfotime, firstvideo, lastvideo) ` +
+		`SELECT id, name, title, release, coalesce(votes, 0), ` +
+		`	coalesce(year, 0), coalesce(rating, 0), nfotime, ` +
+		`	firstvideo, lastvideo FROM items_old`)
+	}
+	if err == nil {
+		_, err = tx.Exec("DROP TABLE items_old")
+	}
+	for _, g := range genres {
+		if err != nil {
+			return
+		}
+		err = dbSetGenres(tx, g.Id, strings.Split(g.Genre, ","))
+	}
+	return
+}
+
+// Version 4: what identifies an item apart from its name. The unique
+// ids from the NFO file, and the device, inode and size of the video.
+func migrateIdentity(tx *sqlx.Tx) (err error) {
+	for _, stmt := range []string{
+		`ALTER TABLE items ADD COLUMN dev INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE items ADD COLUMN ino INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE items ADD COLUMN size INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX items_ino_idx ON items(ino)`,
+		`CREATE TABLE uniqueids(
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			type TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY(item_id, type)
+		)`,
+		`CREATE INDEX uniqueids_value_idx ON uniqueids(type, value)`,
+	} {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Version 5: items that are gone from disk are not deleted right away,
+// but marked as deleted. `scangen' is the generation of the last scan
+// of the collection that saw the item.
+func migrateTombstones(tx *sqlx.Tx) (err error) {
+	for _, stmt := range []string{
+		`ALTER TABLE items ADD COLUMN scangen INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE items ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX items_deleted_idx ON items(deleted)`,
+		`CREATE TABLE scangens(
+			collection TEXT NOT NULL PRIMARY KEY,
+			generation INTEGER NOT NULL
+		)`,
+	} {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Version 6: seasons and episodes have the ids the server gives them
+// (see setEpisodeIds) instead of rowids, so that they stay the same
+// when an item is stored again. The tables, and the files and images
+// that refer to them, are rebuilt empty. Their contents come from the
+// scan, the next one fills them again.
+func migrateEpisodeIds(tx *sqlx.Tx) (err error) {
+	for _, stmt := range []string{
+		`DROP TABLE subtitles`,
+		`DROP TABLE images`,
+		`DROP TABLE files`,
+		`DROP TABLE episodes`,
+		`DROP TABLE seasons`,
+		`CREATE TABLE seasons(
+			id TEXT NOT NULL PRIMARY KEY,
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			seasonno INTEGER NOT NULL,
+			UNIQUE(item_id, seasonno)
+		)`,
+		`CREATE TABLE episodes(
+			id TEXT NOT NULL PRIMARY KEY,
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			season_id TEXT NOT NULL REFERENCES seasons(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			seasonno INTEGER NOT NULL,
+			episodeno INTEGER NOT NULL,
+			lastepisodeno INTEGER NOT NULL DEFAULT 0,
+			absoluteno INTEGER NOT NULL DEFAULT 0,
+			airdate TEXT NOT NULL DEFAULT '',
+			double INTEGER NOT NULL DEFAULT 0,
+			sortname TEXT NOT NULL DEFAULT '',
+			nfotime INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX episodes_item_idx ON episodes(item_id, seasonno, episodeno)`,
+		`CREATE INDEX episodes_season_idx ON episodes(season_id)`,
+		`CREATE INDEX episodes_airdate_idx ON episodes(airdate)`,
+		`CREATE TABLE files(
+			id INTEGER PRIMARY KEY,
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			episode_id TEXT REFERENCES episodes(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			path TEXT NOT NULL
+		)`,
+		`CREATE INDEX files_item_idx ON files(item_id)`,
+		`CREATE INDEX files_episode_idx ON files(episode_id)`,
+		`CREATE TABLE subtitles(
+			id INTEGER PRIMARY KEY,
+			file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+			lang TEXT NOT NULL,
+			format TEXT NOT NULL,
+			path TEXT NOT NULL
+		)`,
+		`CREATE INDEX subtitles_file_idx ON subtitles(file_id)`,
+		`CREATE TABLE images(
+			id INTEGER PRIMARY KEY,
+			item_id TEXT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+			season_id TEXT REFERENCES seasons(id) ON DELETE CASCADE,
+			episode_id TEXT REFERENCES episodes(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			path TEXT NOT NULL
+		)`,
+		`CREATE INDEX images_item_idx ON images(item_id)`,
+	} {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
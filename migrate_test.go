@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Databases from before schema_version are upgraded.
+func TestMigrateLegacy(t *testing.T) {
+	tests := []struct {
+		name		string
+		columns		string
+		values		string
+	}{
+		{ "items", "", "" },
+		{ "items-title", "title TEXT NOT NULL DEFAULT '', " +
+			"release TEXT NOT NULL DEFAULT '',", "'Alien', '', " },
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "test.db")
+			db, err := sqlx.Connect("sqlite3", fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec(`CREATE TABLE items(id TEXT NOT NULL PRIMARY KEY, ` +
+				`name TEXT NOT NULL, ` + tt.columns + ` votes INTEGER, ` +
+				`year INTEGER, genre TEXT NOT NULL, rating REAL, ` +
+				`nfotime INTEGER NOT NULL, firstvideo INTEGER NOT NULL, ` +
+				`lastvideo INTEGER NOT NULL)`)
+			if err == nil {
+				_, err = db.Exec(`INSERT INTO items VALUES ('x', 'Alien', ` +
+					tt.values +
+					`1, 1979, 'Horror,Sci-Fi', 8.5, 1, 2, 3)`)
+			}
+			db.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dbHandle != nil {
+				dbHandle.Close()
+			}
+			if err = dbInit(fn); err != nil {
+				t.Fatal(err)
+			}
+			var version int
+			dbHandle.Get(&version, "SELECT max(version) FROM schema_version")
+			if version != migrations[len(migrations) - 1].version {
+				t.Errorf("version %d", version)
+			}
+			var item struct {
+				Title	string
+				Year	int
+			}
+			err = dbHandle.Get(&item, "SELECT title, year FROM items WHERE id = 'x'")
+			if err != nil || item.Year != 1979 {
+				t.Errorf("item: %+v %v", item, err)
+			}
+			tx, _ := dbHandle.Beginx()
+			genres, _ := dbGetGenres(tx, "x")
+			tx.Rollback()
+			if len(genres) != 2 {
+				t.Errorf("genres %v", genres)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+// Report missing and duplicate episodes.
+//
+// What episodes a show should have comes from:
+//
+// - the `episodes.txt' file in the show's directory. Lines are either
+//   "season number-of-episodes", like "1 13", or an episode name
+//   like "S01E05 Some title".
+// - the <season> and <episode> totals in tvshow.nfo.
+// - the highest episode number we have of a season.
+//
+// Anime with absolute episode numbers and no absolute-episodes.txt
+// are skipped: their episodes are not in real seasons.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+const episodeListFile = "episodes.txt"
+
+type SeasonReport struct {
+	SeasonNo	int		`json:"seasonno"`
+	Episodes	int		`json:"episodes"`
+	Expected	int		`json:"expected,omitempty"`
+	Missing		[]int		`json:"missing,omitempty"`
+	Duplicates	[]int		`json:"duplicates,omitempty"`
+}
+
+type ShowReport struct {
+	Id		string		`json:"id"`
+	Name		string		`json:"name"`
+	Episodes	int		`json:"episodes"`
+	Expected	int		`json:"expected,omitempty"`
+	MissingSeasons	[]int		`json:"missingseasons,omitempty"`
+	Seasons		[]SeasonReport	`json:"seasons,omitempty"`
+}
+
+// Read episodes.txt. Returns the number of episodes per season.
+func readEpisodeList(dir string) (counts map[int]int) {
+	fh, err := vfsOpen(path.Join(dir, episodeListFile))
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+
+	counts = make(map[int]int)
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) == 2 && isNumber(f[0]) && isNumber(f[1]) {
+			counts[parseInt(f[0])] = parseInt(f[1])
+			continue
+		}
+		var ep Episode
+		if parseEpisodeName(nil, line, -1, &ep) {
+			last := ep.EpisodeNo
+			if ep.LastEpisodeNo > last {
+				last = ep.LastEpisodeNo
+			}
+			if last > counts[ep.SeasonNo] {
+				counts[ep.SeasonNo] = last
+			}
+		}
+	}
+	return
+}
+
+func isNumber(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Describe a list of numbers as ranges: 1-3, 7, 9-10.
+func numberRanges(nums []int) string {
+	var r []string
+	for i := 0; i < len(nums); {
+		j := i
+		for j + 1 < len(nums) && nums[j+1] == nums[j] + 1 {
+			j++
+		}
+		if j > i {
+			r = append(r, fmt.Sprintf("%d-%d", nums[i], nums[j]))
+		} else {
+			r = append(r, fmt.Sprintf("%d", nums[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(r, ", ")
+}
+
+func showMissingReport(coll *Collection, show *Item) (rep ShowReport) {
+	rep.Id = show.Id
+	rep.Name = show.Name
+
+	// date based shows do not have episode numbers to compare.
+	if show.Type != "show" || show.EpisodeOrder == "date" {
+		return
+	}
+
+	dir := itemDir(coll, show)
+	if hasAbsoluteEpisodes(show) && len(readAbsoluteMap(dir)) == 0 {
+		return
+	}
+
+	counts := readEpisodeList(dir)
+	nfoSeasons := 0
+	if show.NfoPath != "" {
+		if nfo := loadNfo(show.NfoPath); nfo != nil {
+			rep.Expected = parseInt(nfo.Episode)
+			nfoSeasons = parseInt(nfo.Season)
+		}
+	}
+
+	have := make(map[int]bool)
+	for _, s := range show.Seasons {
+		have[s.SeasonNo] = true
+		sr := SeasonReport{
+			SeasonNo: s.SeasonNo,
+			Expected: counts[s.SeasonNo],
+		}
+		seen := make(map[int]int)
+		max := 0
+		for _, ep := range s.Episodes {
+			last := ep.EpisodeNo
+			if ep.LastEpisodeNo > last {
+				last = ep.LastEpisodeNo
+			}
+			for n := ep.EpisodeNo; n <= last; n++ {
+				seen[n]++
+			}
+			if last > max {
+				max = last
+			}
+		}
+		if sr.Expected > max {
+			max = sr.Expected
+		}
+		for n := 1; n <= max; n++ {
+			switch {
+			case seen[n] == 0 && (s.SeasonNo > 0 || sr.Expected > 0):
+				sr.Missing = append(sr.Missing, n)
+			case seen[n] > 1:
+				sr.Duplicates = append(sr.Duplicates, n)
+			}
+		}
+		sr.Episodes = len(seen)
+		rep.Episodes += sr.Episodes
+		rep.Seasons = append(rep.Seasons, sr)
+	}
+
+	for sn := range counts {
+		if !have[sn] && counts[sn] > 0 {
+			rep.MissingSeasons = append(rep.MissingSeasons, sn)
+			have[sn] = true
+		}
+	}
+	for sn := 1; sn <= nfoSeasons; sn++ {
+		if !have[sn] {
+			rep.MissingSeasons = append(rep.MissingSeasons, sn)
+		}
+	}
+	sort.Ints(rep.MissingSeasons)
+	return
+}
+
+func hasAbsoluteEpisodes(show *Item) bool {
+	for _, s := range show.Seasons {
+		for _, ep := range s.Episodes {
+			if ep.AbsoluteNo > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Does the report show any problems.
+func (rep *ShowReport) problems() bool {
+	if len(rep.MissingSeasons) > 0 ||
+	   (rep.Expected > 0 && rep.Episodes < rep.Expected) {
+		return true
+	}
+	for _, s := range rep.Seasons {
+		if len(s.Missing) > 0 || len(s.Duplicates) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func collectionMissingReport(coll *Collection) (reps []ShowReport) {
+	reps = []ShowReport{}
+	for _, item := range coll.Items {
+		rep := showMissingReport(coll, item)
+		if rep.problems() {
+			reps = append(reps, rep)
+		}
+	}
+	sort.Slice(reps, func(i, j int) bool {
+		return reps[i].Name < reps[j].Name
+	})
+	return
+}
+
+func printMissingReport(w io.Writer, reps []ShowReport) {
+	for _, rep := range reps {
+		fmt.Fprintf(w, "%s\n", rep.Name)
+		if rep.Expected > 0 && rep.Episodes < rep.Expected {
+			fmt.Fprintf(w, "  %d of %d episodes\n",
+				rep.Episodes, rep.Expected)
+		}
+		if len(rep.MissingSeasons) > 0 {
+			fmt.Fprintf(w, "  missing seasons: %s\n",
+				numberRanges(rep.MissingSeasons))
+		}
+		for _, s := range rep.Seasons {
+			if len(s.Missing) > 0 {
+				fmt.Fprintf(w, "  season %d: missing %s\n",
+					s.SeasonNo, numberRanges(s.Missing))
+			}
+			if len(s.Duplicates) > 0 {
+				fmt.Fprintf(w, "  season %d: duplicate %s\n",
+					s.SeasonNo, numberRanges(s.Duplicates))
+			}
+		}
+	}
+}
+
+// Scan the show collections (or just `collName') and print the report.
+// The database is not changed.
+func missingReportCLI(w io.Writer, collName string) {
+	for i := range config.Collections {
+		c := &(config.Collections[i])
+		if collName != "" && c.Name_ != collName {
+			continue
+		}
+		if c.Type != "shows" && c.Type != "anime" {
+			continue
+		}
+		scanForReport(c, i + 1)
+		fmt.Fprintf(w, "== %s\n", c.Name_)
+		printMissingReport(w, collectionMissingReport(c))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShowMissingReport(t *testing.T) {
+	tests := []struct {
+		name		string
+		typ		string
+		files		map[string]string
+		seasons		[]int
+		missing		[]int
+	}{
+		// season totals from tvshow.nfo.
+		{ "nfo", "shows", map[string]string{
+			"Show/tvshow.nfo":	"<tvshow><title>Show</title><season>3</season></tvshow>",
+			"Show/S1/Show.S01E01.mp4": "video",
+			"Show/S1/Show.S01E03.mp4": "video",
+		}, []int{ 2, 3 }, []int{ 2 } },
+		// absolute numbers without a map are not in real seasons.
+		{ "absolute", "anime", map[string]string{
+			"Anime/tvshow.nfo":	"<tvshow><title>Anime</title><season>2</season></tvshow>",
+			"Anime/Anime - 13.mp4":	"video",
+			"Anime/Anime - 15.mp4":	"video",
+		}, nil, nil },
+		{ "absolute-map", "anime", map[string]string{
+			"Anime/tvshow.nfo":	"<tvshow><title>Anime</title></tvshow>",
+			"Anime/absolute-episodes.txt": "1 1\n2 13\n",
+			"Anime/Anime - 13.mp4":	"video",
+			"Anime/Anime - 15.mp4":	"video",
+		}, nil, []int{ 2 } },
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDb(t)
+			coll := &Collection{
+				Name_: "test",
+				Type: tt.typ,
+				Directory: testMountMem(t, tt.files),
+			}
+			items := buildShows(coll, 0)
+			if len(items) != 1 {
+				t.Fatalf("got %d shows, want 1", len(items))
+			}
+			rep := showMissingReport(coll, items[0])
+			if !reflect.DeepEqual(rep.MissingSeasons, tt.seasons) {
+				t.Errorf("missing seasons %v, want %v", rep.MissingSeasons, tt.seasons)
+			}
+			var missing []int
+			for _, s := range rep.Seasons {
+				missing = append(missing, s.Missing...)
+			}
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("missing episodes %v, want %v", missing, tt.missing)
+			}
+		})
+	}
+}
+
+// The report scans, but does not write to the database.
+func TestMissingReportCLI(t *testing.T) {
+	testDb(t)
+	saved := config.Collections
+	defer func() { config.Collections = saved }()
+	config.Collections = []Collection{{
+		Name_: "test",
+		Type: "shows",
+		Directory: testMountMem(t, map[string]string{
+			"Show/tvshow.nfo":	"<tvshow><title>Show</title></tvshow>",
+			"Show/S1/Show.S01E01.mp4": "video",
+			"Show/S1/Show.S01E03.mp4": "video",
+		}),
+	}}
+	var buf bytes.Buffer
+	missingReportCLI(&buf, "")
+	if !strings.Contains(buf.String(), "season 1: missing 2") {
+		t.Errorf("report: %q", buf.String())
+	}
+	for _, table := range []string{ "items", "snapshots", "scangens" } {
+		var n int
+		dbHandle.Get(&n, "SELECT count(*) FROM " + table)
+		if n != 0 {
+			t.Errorf("%s: %d rows written", table, n)
+		}
+	}
+}
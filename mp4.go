@@ -0,0 +1,121 @@
+// Minimal reader for MP4 (ISO base media file format) boxes.
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+type mp4Box struct {
+	typ		string
+	offset		int64
+	size		int64
+}
+
+// seconds between 1904-01-01 (the mp4 epoch) and 1970-01-01.
+const mp4Epoch = 2082844800
+
+// List the boxes between `start' and `end'.
+func mp4Boxes(r io.ReaderAt, start int64, end int64) (boxes []mp4Box) {
+	var hdr [16]byte
+	for pos := start; pos + 8 <= end; {
+		if _, err := r.ReadAt(hdr[:8], pos); err != nil {
+			return
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		hlen := int64(8)
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], pos + 8); err != nil {
+				return
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			hlen = 16
+		}
+		if size < hlen || pos + size > end {
+			return
+		}
+		boxes = append(boxes, mp4Box{
+			typ: string(hdr[4:8]),
+			offset: pos + hlen,
+			size: size - hlen,
+		})
+		pos += size
+	}
+	return
+}
+
+// Find a box by path, like "moov", "udta", "meta", "ilst".
+func mp4Find(r io.ReaderAt, size int64, path ...string) (box mp4Box, ok bool) {
+	start, end := int64(0), size
+	for i, typ := range path {
+		ok = false
+		for _, b := range mp4Boxes(r, start, end) {
+			if b.typ == typ {
+				box = b
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return
+		}
+		start, end = box.offset, box.offset + box.size
+		if typ == "meta" && i < len(path) - 1 {
+			// usually a "full box" with 4 bytes version/flags,
+			// but not always (quicktime).
+			var b [8]byte
+			r.ReadAt(b[:], start)
+			if string(b[4:8]) != "hdlr" {
+				start += 4
+			}
+		}
+	}
+	return
+}
+
+func mp4Read(r io.ReaderAt, box mp4Box, max int64) (buf []byte, err error) {
+	n := box.size
+	if n > max {
+		n = max
+	}
+	buf = make([]byte, n)
+	_, err = r.ReadAt(buf, box.offset)
+	return
+}
+
+// Creation time and duration (in seconds) from the movie header.
+func mp4MovieHeader(r io.ReaderAt, size int64) (created time.Time, duration float64, ok bool) {
+	box, found := mp4Find(r, size, "moov", "mvhd")
+	if !found {
+		return
+	}
+	b, err := mp4Read(r, box, 32)
+	if err != nil || len(b) < 20 {
+		return
+	}
+	var ctime, timescale, dur uint64
+	if b[0] == 1 {
+		if len(b) < 32 {
+			return
+		}
+		ctime = binary.BigEndian.Uint64(b[4:12])
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		dur = binary.BigEndian.Uint64(b[24:32])
+	} else {
+		ctime = uint64(binary.BigEndian.Uint32(b[4:8]))
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		dur = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+	if ctime > mp4Epoch {
+		created = time.Unix(int64(ctime - mp4Epoch), 0)
+	}
+	if timescale > 0 {
+		duration = float64(dur) / float64(timescale)
+	}
+	ok = true
+	return
+}
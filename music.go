@@ -0,0 +1,423 @@
+// Collections of type "music".
+//
+// The layout is Artist/Album/tracks. Albums can have the tracks in
+// disc subdirectories (CD1, Disc 2). Tracks directly in an artist
+// directory are put in an album of their own. Album art is
+// cover.jpg / folder.jpg / front.jpg in the album directory,
+// artist art is artist.jpg / folder.jpg in the artist directory.
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var isAudio = regexp.MustCompile(`^(.*)\.(mp3|MP3|flac|FLAC|m4a|M4A|ogg|OGG|oga|opus|wav|WAV)$`)
+var isDiscSubdir = regexp.MustCompile(`(?i)^(?:cd|disc|disk) ?([0-9]+)$`)
+var isTrackPrefix = regexp.MustCompile(`^([0-9]{1,3})(?:[ .-]+|$)`)
+var isAlbumYear = regexp.MustCompile(`^((?:19|20)[0-9]{2}) ?- ?(.+)$|^(.+?) ?[(\[]((?:19|20)[0-9]{2})[)\]]$`)
+
+// Content types of audio files, most of them are not
+// in the builtin mime table of Go.
+var audioTypes = map[string]string{
+	"mp3":	"audio/mpeg",
+	"flac":	"audio/flac",
+	"m4a":	"audio/mp4",
+	"ogg":	"audio/ogg",
+	"oga":	"audio/ogg",
+	"opus":	"audio/ogg",
+	"wav":	"audio/wav",
+}
+
+type MusicLibrary struct {
+	Artists		[]*Artist
+	Albums		[]*Album
+	Tracks		[]*Track
+	LastModified	int64
+}
+
+type Artist struct {
+	Id		string		`json:"id"`
+	Name		string		`json:"name"`
+	Path		string		`json:"path"`
+	BaseUrl		string		`json:"baseurl"`
+	Thumb		string		`json:"thumb,omitempty"`
+	Fanart		string		`json:"fanart,omitempty"`
+	AlbumCount	int		`json:"albumcount"`
+	Albums		[]*Album	`json:"albums,omitempty"`
+}
+
+type Album struct {
+	Id		string		`json:"id"`
+	Name		string		`json:"name"`
+	Artist		string		`json:"artist"`
+	ArtistId	string		`json:"artistid"`
+	Path		string		`json:"path"`
+	BaseUrl		string		`json:"baseurl"`
+	Cover		string		`json:"cover,omitempty"`
+	Year		int		`json:"year,omitempty"`
+	Genre		[]string	`json:"genre,omitempty"`
+	Duration	float64		`json:"duration,omitempty"`
+	TrackCount	int		`json:"trackcount"`
+	Tracks		[]*Track	`json:"tracks,omitempty"`
+}
+
+type Track struct {
+	Id		string		`json:"id"`
+	Title		string		`json:"title"`
+	Artist		string		`json:"artist,omitempty"`
+	Album		string		`json:"album"`
+	AlbumId		string		`json:"albumid"`
+	ArtistId	string		`json:"artistid"`
+	Path		string		`json:"path"`
+	BaseUrl		string		`json:"baseurl"`
+	TrackNo		int		`json:"trackno,omitempty"`
+	DiscNo		int		`json:"discno,omitempty"`
+	Year		int		`json:"year,omitempty"`
+	Genre		string		`json:"genre,omitempty"`
+	Duration	float64		`json:"duration,omitempty"`
+
+	size		int64
+	modTime		int64
+}
+
+func buildMusic(coll *Collection, pace int) {
+	// tags of unchanged files are re-used from the previous scan.
+	prev := map[string]*Track{}
+	if coll.music != nil {
+		for _, t := range coll.music.Tracks {
+			prev[t.Path] = t
+		}
+	}
+
+	ign := newIgnoreMatcher(coll)
+	d := coll.Directory
+	f, err := OpenDir(d)
+	if err != nil {
+		coll.scanError("", d, "cannot read directory: %s", err)
+		return
+	}
+	fi, _ := f.Readdir(0)
+	f.Close()
+	fi = ign.filter(d, fi)
+
+	lib := &MusicLibrary{}
+	for i := range fi {
+		name := fi[i].Name()
+		if !fi[i].IsDir() {
+			if isAudio.MatchString(name) {
+				coll.diag(diagWarning, "", path.Join(d, name),
+					"audio file not in an artist directory")
+			}
+			continue
+		}
+		artist := buildArtist(coll, ign, name, prev, pace)
+		if artist == nil {
+			continue
+		}
+		lib.Artists = append(lib.Artists, artist)
+	}
+	sort.Slice(lib.Artists, func(i, j int) bool {
+		return sortName(lib.Artists[i].Name) < sortName(lib.Artists[j].Name)
+	})
+	for _, artist := range lib.Artists {
+		for _, a := range artist.Albums {
+			lib.Albums = append(lib.Albums, a)
+			lib.Tracks = append(lib.Tracks, a.Tracks...)
+		}
+	}
+	for _, t := range lib.Tracks {
+		if t.modTime > lib.LastModified {
+			lib.LastModified = t.modTime
+		}
+	}
+	coll.music = lib
+}
+
+// "The Beatles" sorts as "beatles".
+func sortName(s string) string {
+	s = strings.ToLower(s)
+	return strings.TrimPrefix(s, "the ")
+}
+
+func buildArtist(coll *Collection, ign *ignoreMatcher, dir string, prev map[string]*Track, pace int) (artist *Artist) {
+	d := path.Join(coll.Directory, dir)
+	f, err := OpenDir(d)
+	if err != nil {
+		coll.scanError(dir, d, "cannot read directory: %s", err)
+		return
+	}
+	fi, _ := f.Readdir(0)
+	f.Close()
+	ign = ign.sub(d)
+	fi = ign.filter(d, fi)
+
+	artist = &Artist{
+		Id: idHash(coll.Name_ + "/" + dir),
+		Name: dir,
+		Path: escapePath(dir),
+		BaseUrl: coll.BaseUrl,
+	}
+
+	var loose []FileInfo
+	for i := range fi {
+		name := fi[i].Name()
+		if fi[i].IsDir() {
+			album := buildAlbum(coll, ign, artist, path.Join(dir, name), prev)
+			if album != nil {
+				artist.Albums = append(artist.Albums, album)
+			}
+			if pace > 0 {
+				time.Sleep(time.Duration(int64(pace)) * time.Second)
+			}
+			continue
+		}
+		if isAudio.MatchString(name) {
+			loose = append(loose, fi[i])
+			continue
+		}
+		s := isImage.FindStringSubmatch(name)
+		if len(s) == 0 {
+			continue
+		}
+		switch strings.ToLower(s[1]) {
+		case "artist", "folder", "thumb":
+			if artist.Thumb == "" || strings.ToLower(s[1]) == "artist" {
+				artist.Thumb = escapePath(name)
+			}
+		case "fanart", "backdrop":
+			artist.Fanart = escapePath(name)
+		}
+	}
+
+	// tracks in the artist directory itself.
+	if len(loose) > 0 {
+		album := newAlbum(coll, artist, dir)
+		album.Name = ""
+		addTracks(coll, album, dir, loose, 0, prev)
+		for _, t := range album.Tracks {
+			if t.Album != "" {
+				album.Name = t.Album
+				break
+			}
+		}
+		if album.Name == "" {
+			album.Name = "Singles"
+		}
+		finishAlbum(album)
+		artist.Albums = append(artist.Albums, album)
+	}
+
+	if len(artist.Albums) == 0 {
+		coll.diag(diagInfo, dir, d, "no albums found")
+		artist = nil
+		return
+	}
+	sort.Slice(artist.Albums, func(i, j int) bool {
+		a, b := artist.Albums[i], artist.Albums[j]
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		return a.Name < b.Name
+	})
+	artist.AlbumCount = len(artist.Albums)
+	return
+}
+
+func newAlbum(coll *Collection, artist *Artist, dir string) (album *Album) {
+	name := path.Base(dir)
+	year := 0
+	if s := isAlbumYear.FindStringSubmatch(name); len(s) > 0 {
+		if s[1] != "" {
+			year, name = parseInt(s[1]), s[2]
+		} else {
+			year, name = parseInt(s[4]), s[3]
+		}
+	}
+	album = &Album{
+		Id: idHash(coll.Name_ + "/" + dir + "/"),
+		Name: name,
+		Year: year,
+		Artist: artist.Name,
+		ArtistId: artist.Id,
+		Path: escapePath(dir),
+		BaseUrl: coll.BaseUrl,
+	}
+	return
+}
+
+func buildAlbum(coll *Collection, ign *ignoreMatcher, artist *Artist, dir string, prev map[string]*Track) (album *Album) {
+	d := path.Join(coll.Directory, dir)
+	f, err := OpenDir(d)
+	if err != nil {
+		coll.scanError(artist.Name, d, "cannot read directory: %s", err)
+		return
+	}
+	fi, _ := f.Readdir(0)
+	f.Close()
+	ign = ign.sub(d)
+	fi = ign.filter(d, fi)
+
+	album = newAlbum(coll, artist, dir)
+	var tracks []FileInfo
+	cover, coverPrio := "", 0
+	for i := range fi {
+		name := fi[i].Name()
+		if fi[i].IsDir() {
+			s := isDiscSubdir.FindStringSubmatch(name)
+			if len(s) == 0 {
+				continue
+			}
+			sd := path.Join(d, name)
+			if sf, err := OpenDir(sd); err == nil {
+				dfi, _ := sf.Readdir(0)
+				sf.Close()
+				dfi = ign.sub(sd).filter(sd, dfi)
+				addTracks(coll, album, path.Join(dir, name), dfi,
+					parseInt(s[1]), prev)
+			}
+			continue
+		}
+		if isAudio.MatchString(name) {
+			tracks = append(tracks, fi[i])
+			continue
+		}
+		s := isImage.FindStringSubmatch(name)
+		if len(s) == 0 {
+			continue
+		}
+		prio := 1
+		switch strings.ToLower(s[1]) {
+		case "cover", "front":
+			prio = 4
+		case "folder":
+			prio = 3
+		case "album", "thumb":
+			prio = 2
+		}
+		if prio > coverPrio {
+			cover, coverPrio = escapePath(name), prio
+		}
+	}
+	addTracks(coll, album, dir, tracks, 0, prev)
+
+	if len(album.Tracks) == 0 {
+		album = nil
+		return
+	}
+	album.Cover = cover
+	if cover == "" {
+		coll.diag(diagInfo, artist.Name, d, "no cover art")
+	}
+	finishAlbum(album)
+	return
+}
+
+// Read the tags of the audio files in `fi', and add them to the album.
+func addTracks(coll *Collection, album *Album, dir string, fi []FileInfo, discNo int, prev map[string]*Track) {
+	for i := range fi {
+		s := isAudio.FindStringSubmatch(fi[i].Name())
+		if len(s) == 0 {
+			continue
+		}
+		p := escapePath(path.Join(dir, s[0]))
+		modTime := fi[i].Modtime().UnixMilli()
+		size := fi[i].Size()
+
+		if t, ok := prev[p]; ok && t.modTime == modTime && t.size == size {
+			// a copy, the old one might still be being served.
+			t2 := *t
+			album.Tracks = append(album.Tracks, &t2)
+			continue
+		}
+
+		fn := path.Join(coll.Directory, dir, s[0])
+		tags := readAudioTags(fn)
+		if tags == nil {
+			coll.diag(diagInfo, album.Artist, fn, "no tags found")
+			tags = &AudioTags{}
+		}
+		t := &Track{
+			Id: idHash(coll.Name_ + "/" + path.Join(dir, s[0])),
+			Title: tags.Title,
+			Artist: tags.Artist,
+			Album: tags.Album,
+			Path: p,
+			BaseUrl: coll.BaseUrl,
+			TrackNo: tags.TrackNo,
+			DiscNo: tags.DiscNo,
+			Year: tags.Year,
+			Genre: tags.Genre,
+			Duration: tags.Duration,
+			size: size,
+			modTime: modTime,
+		}
+		if t.Title == "" {
+			t.Title = isTrackPrefix.ReplaceAllString(s[1], "")
+			if t.Title == "" {
+				t.Title = s[1]
+			}
+		}
+		if n := isTrackPrefix.FindStringSubmatch(s[1]); t.TrackNo == 0 && len(n) > 0 {
+			t.TrackNo = parseInt(n[1])
+		}
+		if t.DiscNo == 0 {
+			t.DiscNo = discNo
+		}
+		album.Tracks = append(album.Tracks, t)
+	}
+}
+
+// Fill in the album from the track tags, and sort the tracks.
+func finishAlbum(album *Album) {
+	genres := map[string]bool{}
+	album.Duration = 0
+	for _, t := range album.Tracks {
+		if album.Name == "" && t.Album != "" {
+			album.Name = t.Album
+		}
+		if album.Year == 0 && t.Year > 0 {
+			album.Year = t.Year
+		}
+		if t.Genre != "" && !genres[t.Genre] {
+			genres[t.Genre] = true
+			album.Genre = append(album.Genre, t.Genre)
+		}
+		album.Duration += t.Duration
+		t.AlbumId = album.Id
+		t.ArtistId = album.ArtistId
+		t.Album = album.Name
+	}
+	album.TrackCount = len(album.Tracks)
+	sort.SliceStable(album.Tracks, func(i, j int) bool {
+		a, b := album.Tracks[i], album.Tracks[j]
+		if a.DiscNo != b.DiscNo {
+			return a.DiscNo < b.DiscNo
+		}
+		if a.TrackNo != b.TrackNo {
+			return a.TrackNo < b.TrackNo
+		}
+		return a.Path < b.Path
+	})
+}
+
+func getArtist(lib *MusicLibrary, name string) *Artist {
+	for _, a := range lib.Artists {
+		if a.Id == name || a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func getAlbum(lib *MusicLibrary, id string) *Album {
+	for _, a := range lib.Albums {
+		if a.Id == id {
+			return a
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Write `Kodi' style .NFO files.
+//
+// When updating an existing NFO file, only the elements that are
+// changed get replaced. All other elements, including the ones we
+// do not know about, are copied over verbatim.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrMultiEpisode = errors.New("cannot update a multi-episode NFO file")
+var ErrReadOnly = errors.New("collection is read-only")
+
+// Fields that can be updated. Fields that are nil are left alone,
+// fields that are set to the zero value are removed.
+type NfoUpdate struct {
+	Title		*string		`json:"title"`
+	OTitle		*string		`json:"originaltitle"`
+	Year		*int		`json:"year"`
+	Plot		*string		`json:"plot"`
+	Tagline		*string		`json:"tagline"`
+	Premiered	*string		`json:"premiered"`
+	Aired		*string		`json:"aired"`
+	Season		*int		`json:"season"`
+	Episode		*int		`json:"episode"`
+	Mpaa		*string		`json:"mpaa"`
+	Studio		*string		`json:"studio"`
+	Director	*string		`json:"director"`
+	Credits		*string		`json:"credits"`
+	Rating		*float32	`json:"rating"`
+	Votes		*int		`json:"votes"`
+	Genre		[]string	`json:"genre"`
+}
+
+// An NFO file as a generic list of elements.
+type nfoDoc struct {
+	XMLName		xml.Name
+	Attrs		[]xml.Attr	`xml:",any,attr"`
+	Elems		[]nfoElem	`xml:",any"`
+}
+
+type nfoElem struct {
+	XMLName		xml.Name
+	Attrs		[]xml.Attr	`xml:",any,attr"`
+	Inner		[]byte		`xml:",innerxml"`
+}
+
+// Read an NFO file. If it does not exist, return an empty
+// document with a `root' element.
+func readNfoDoc(fn string, root string) (doc *nfoDoc, err error) {
+	doc = &nfoDoc{ XMLName: xml.Name{ Local: root } }
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer fh.Close()
+	buf, err := io.ReadAll(fh)
+	if err != nil {
+		return
+	}
+	buf = bytes.TrimPrefix(buf, []byte(utf8BOM))
+
+	d := xml.NewDecoder(bytes.NewReader(buf))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	err = d.Decode(doc)
+	if err != nil {
+		return
+	}
+	if doc.XMLName.Local == "xbmcmultiepisode" {
+		err = ErrMultiEpisode
+	}
+	return
+}
+
+// Replace all elements called `name' by new elements with `values'.
+// The new elements go where the first old element was.
+func (doc *nfoDoc) set(name string, values ...string) {
+	pos := -1
+	elems := make([]nfoElem, 0, len(doc.Elems) + len(values))
+	for _, e := range doc.Elems {
+		if e.XMLName.Local == name {
+			if pos < 0 {
+				pos = len(elems)
+			}
+			continue
+		}
+		elems = append(elems, e)
+	}
+	if pos < 0 {
+		pos = len(elems)
+	}
+
+	var nw []nfoElem
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(v))
+		nw = append(nw, nfoElem{
+			XMLName: xml.Name{ Local: name },
+			Inner: b.Bytes(),
+		})
+	}
+	tail := append(nw, elems[pos:]...)
+	doc.Elems = append(elems[:pos], tail...)
+}
+
+func (doc *nfoDoc) setString(name string, v *string) {
+	if v != nil {
+		doc.set(name, *v)
+	}
+}
+
+func (doc *nfoDoc) setInt(name string, v *int) {
+	if v != nil {
+		s := ""
+		if *v != 0 {
+			s = strconv.Itoa(*v)
+		}
+		doc.set(name, s)
+	}
+}
+
+func (doc *nfoDoc) update(u *NfoUpdate) {
+	doc.setString("title", u.Title)
+	doc.setString("originaltitle", u.OTitle)
+	doc.setInt("year", u.Year)
+	doc.setString("plot", u.Plot)
+	doc.setString("tagline", u.Tagline)
+	doc.setString("premiered", u.Premiered)
+	doc.setString("aired", u.Aired)
+	doc.setInt("season", u.Season)
+	doc.setInt("episode", u.Episode)
+	doc.setString("mpaa", u.Mpaa)
+	doc.setString("studio", u.Studio)
+	doc.setString("director", u.Director)
+	doc.setString("credits", u.Credits)
+	if u.Rating != nil {
+		s := ""
+		if *u.Rating != 0 {
+			s = strconv.FormatFloat(float64(*u.Rating), 'f', -1, 32)
+		}
+		doc.set("rating", s)
+	}
+	doc.setInt("votes", u.Votes)
+	if u.Genre != nil {
+		doc.set("genre", normalizeGenres(u.Genre)...)
+	}
+}
+
+// Write the document to a temporary file, then rename it into place.
+func writeNfoDoc(fn string, doc *nfoDoc) (err error) {
+	buf, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return
+	}
+	hdr := `<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>`
+	data := strings.Join([]string{ hdr, string(buf), "" }, "\n")
+
+	tmp := fn + tmpExt
+	err = os.WriteFile(tmp, []byte(data), 0644)
+	if err == nil {
+		err = os.Rename(tmp, fn)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// Apply an update to the NFO file `fn'. If the file does not exist
+// yet, it is created with root element `root'.
+func updateNfo(fn string, root string, u *NfoUpdate) (err error) {
+	// archives and the like.
+	if isMounted(fn) {
+		err = ErrReadOnly
+		return
+	}
+	doc, err := readNfoDoc(fn, root)
+	if err != nil {
+		return
+	}
+	doc.update(u)
+	err = writeNfoDoc(fn, doc)
+	return
+}
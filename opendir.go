@@ -6,6 +6,7 @@ package main
 
 import (
 	"errors"
+	"io/fs"
 	"os"
 	"path"
 	"time"
@@ -13,7 +14,9 @@ import (
 
 type Dir struct {
 	name	string
-	file	*os.File
+	fsys	fs.FS
+	rel	string
+	info	fs.FileInfo
 }
 
 type FileInfo struct {
@@ -28,14 +31,27 @@ type FileInfo struct {
 	didstat	bool
 }
 
+// Filesystems that know when a file was created return
+// something that implements this from FileInfo.Sys().
+type createtimer interface {
+	Createtime() time.Time
+}
+
 var NotDirectory = errors.New("Not a directory")
 
+func isNotDirectory(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err == NotDirectory
+	}
+	return false
+}
+
 func OpenDir(name string) (dir  *Dir, err error) {
-	f, err := os.Open(name)
+	fsys, rel := lookupFS(name)
+	fi, err := fs.Stat(fsys, rel)
 	if err != nil {
 		return
 	}
-	fi, _ := f.Stat()
 	if !fi.IsDir() {
 		err = &os.PathError{
 			Op: "Opendir",
@@ -46,21 +62,35 @@ func OpenDir(name string) (dir  *Dir, err error) {
 	}
 	dir = &Dir{
 		name: name,
-		file: f,
+		fsys: fsys,
+		rel: rel,
+		info: fi,
 	}
 	return
 }
 
+// The directory is only read by Readdir, nothing is kept open.
 func (dir *Dir) Close() error {
-	return dir.file.Close()
+	return nil
 }
 
 func (dir *Dir) Stat() (os.FileInfo, error) {
-	return dir.file.Stat()
+	return dir.info, nil
 }
 
 func (dir *Dir) Readdirnames(n int) (names []string, err error) {
-	return dir.file.Readdirnames(n)
+	de, err := fs.ReadDir(dir.fsys, dir.rel)
+	if err != nil {
+		return
+	}
+	if n > 0 && len(de) > n {
+		de = de[:n]
+	}
+	names = make([]string, len(de))
+	for i := range de {
+		names[i] = de[i].Name()
+	}
+	return
 }
 
 func (dir *Dir) Readdir(n int) (fi []FileInfo, err error) {
@@ -114,8 +144,8 @@ func (fi *FileInfo) stat() {
 	if fi.didstat {
 		return
 	}
-	p := path.Join(fi.dir.name, fi.name)
-	s, err := os.Stat(p)
+	p := path.Join(fi.dir.rel, fi.name)
+	s, err := fs.Stat(fi.dir.fsys, p)
 	if err != nil {
 		return
 	}
@@ -134,9 +164,23 @@ func (fi *FileInfo) set(s os.FileInfo) {
 	return
 }
 
+// Creation time from the filesystem, or else the modification time.
+func (fi *FileInfo) setCreatetime() () {
+	fi.createtime = fi.modtime
+	switch sys := fi.sys.(type) {
+	case createtimer:
+		if t := sys.Createtime(); !t.IsZero() {
+			fi.createtime = t
+		}
+	case nil:
+	default:
+		if t, ok := sysCreatetime(sys, fi.modtime); ok {
+			fi.createtime = t
+		}
+	}
+}
 
 func (fi *FileInfo) Sys() interface{} {
 	fi.stat()
 	return fi.sys
 }
-
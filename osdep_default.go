@@ -1,12 +1,16 @@
-//go:build (!freebsd && !linux)
+//go:build !freebsd && !linux
+// +build !freebsd,!linux
 
 package main
 
 import (
-        "syscall"
         "time"
 )
 
-func (fi *FileInfo) setCreatetime() () {
-	fi.createtime = fi.modtime
+func sysCreatetime(sys interface{}, mtime time.Time) (t time.Time, ok bool) {
+	return
+}
+
+func sysFileId(sys interface{}) (dev, ino int64, ok bool) {
+	return
 }
@@ -5,14 +5,23 @@ import (
         "time"
 )
 
-func (fi *FileInfo) setCreatetime() () {
-	if fi.sys == nil {
-		return;
+func sysCreatetime(sys interface{}, mtime time.Time) (t time.Time, ok bool) {
+	stat, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return
 	}
-	stat := fi.sys.(*syscall.Stat_t)
 	nsec := syscall.TimespecToNsec(stat.Ctimespec)
-	fi.createtime = time.Unix(0, nsec)
-	if fi.modtime.Before(fi.createtime) {
-		fi.createtime = fi.modtime
+	t = time.Unix(0, nsec)
+	if mtime.Before(t) {
+		t = mtime
 	}
+	return
+}
+
+func sysFileId(sys interface{}) (dev, ino int64, ok bool) {
+	stat, ok := sys.(*syscall.Stat_t)
+	if ok {
+		dev, ino = int64(stat.Dev), int64(stat.Ino)
+	}
+	return
 }
@@ -1,5 +1,3 @@
-// +build !freebsd
-
 package main
 
 import (
@@ -7,20 +5,29 @@ import (
         "time"
 )
 
-func (fi *FileInfo) setCreatetime() () {
-	if fi.sys == nil {
-		fi.createtime = fi.modtime
+// The oldest of atime, ctime and mtime.
+func sysCreatetime(sys interface{}, mtime time.Time) (t time.Time, ok bool) {
+	stat, ok := sys.(*syscall.Stat_t)
+	if !ok {
 		return
 	}
-	stat := sys.(*syscall.Stat_t)
 	atime := time.Unix(stat.Atim.Sec, stat.Atim.Nsec)
 	ctime := time.Unix(stat.Ctim.Sec, stat.Ctim.Nsec)
-	mtime := time.Unix(stat.Mtim.Sec, stat.Mtim.Nsec)
-	fi.createtime = mtime
-	if atime.Before(fi.createtime) {
-		fi.createtime = atime
+	t = mtime
+	if atime.Before(t) {
+		t = atime
+	}
+	if ctime.Before(t) {
+		t = ctime
 	}
-	if ctime.Before(fi.createtime) {
-		fi.createtime = ctime
+	return
+}
+
+// Device and inode number of a file.
+func sysFileId(sys interface{}) (dev, ino int64, ok bool) {
+	stat, ok := sys.(*syscall.Stat_t)
+	if ok {
+		dev, ino = int64(stat.Dev), int64(stat.Ino)
 	}
+	return
 }
@@ -1,5 +1,18 @@
-// Parse filename for season / epside info.
-// Example: easy.s01e04.mp4 -> season 1, episode .
+// Parse filename for season / episode info.
+// Example: easy.s01e04.mp4 -> season 1, episode 4.
+//
+// The patterns are tried in order, the first one that matches wins.
+// Patterns use named groups:
+//
+//	season		season number
+//	episode		episode number
+//	episodes	one or more episode numbers, like "e01e02" or "01-02"
+//	absolute	absolute episode number (anime style)
+//	year, month, day	air date
+//	show		name of the show (optional)
+//
+// Extra patterns can be configured per collection with `episode-pattern',
+// those are tried before the builtin ones.
 package main
 
 import (
@@ -8,17 +21,93 @@ import (
 	"strconv"
 )
 
-// pattern: ___.s03e04.___
-var pat1 = regexp.MustCompile(`^.*[ ._][sS]([0-9]+)[eE]([0-9]+)[ ._].*$`)
+type episodePattern struct {
+	name		string
+	re		*regexp.Regexp
+	// only match if there is a season hint (from the directory).
+	needHint	bool
+	// season in the filename must be the same as the hint.
+	matchHint	bool
+	// the season+episode number looks like a year, skip it.
+	notYear		bool
+	// only used in, or not used in, anime collections.
+	animeOnly	bool
+	notAnime	bool
+}
+
+// Result of parsing an episode filename.
+type EpisodeInfo struct {
+	Pattern		string		`json:"pattern"`
+	Show		string		`json:"show,omitempty"`
+	SeasonNo	int		`json:"seasonno"`
+	EpisodeNo	int		`json:"episodeno"`
+	LastEpisodeNo	int		`json:"lastepisodeno,omitempty"`
+	AbsoluteNo	int		`json:"absoluteno,omitempty"`
+	AirDate		string		`json:"airdate,omitempty"`
+}
 
-// pattern: ___.s03e04e05.___ or ___.s03e04-e05.___
-var pat2 = regexp.MustCompile(`^.*[. _[sS]([0-9]+)[eE]([0-9]+)-?[eE]([0-9]+)[. _].*$`)
+// separators before and after a match.
+const epPre = `(?:^|[ ._\-\[(])`
+const epPost = `(?:$|[ ._\-\])])`
 
-// pattern: ___.2015.03.08.___
-var pat3 = regexp.MustCompile(`^.*[ .]([0-9]{4})[.-]([0-9]{2})[.-]([0-9]{2})[ .].*$`)
+var builtinEpisodePatterns = []*episodePattern{
+	// ___.s03e04.___, ___.s03e04e05e06.___, ___.s03e04-e05.___
+	{
+		name: "SxxExx",
+		re: regexp.MustCompile(epPre + `[sS](?P<season>[0-9]{1,3})[ ._-]?` +
+			`(?P<episodes>[eE][0-9]{1,4}(?:(?:[ ._-]?[eE]|-)[0-9]{1,4})*)` +
+			epPost),
+	},
+	// ___.3x08.___, ___.3x08x09.___, ___.3x08-09.___
+	{
+		name: "NxNN",
+		re: regexp.MustCompile(epPre + `(?P<season>[0-9]{1,2})x` +
+			`(?P<episodes>[0-9]{2,3}(?:[x-][0-9]{2,3})*)` + epPost),
+	},
+	// ___.2015.03.08.___
+	{
+		name: "date",
+		re: regexp.MustCompile(epPre + `(?P<year>(?:19|20)[0-9]{2})[ .-]` +
+			`(?P<month>[01][0-9])[ .-](?P<day>[0-3][0-9])` + epPost),
+	},
+	// [Group] Show - 123 [1080p]
+	{
+		name: "absolute",
+		re: regexp.MustCompile(`^\[[^\]]*\][ _]*(?P<show>.+?)[ _]-[ _]` +
+			`(?P<absolute>[0-9]{1,4})(?:v[0-9])?(?:$|[ ._\[(])`),
+	},
+	// Show - 123, only in anime collections.
+	{
+		name: "absolute",
+		re: regexp.MustCompile(`^(?P<show>.+?)[ _]-[ _](?:[eE][pP]?|#)?` +
+			`(?P<absolute>[0-9]{1,4})(?:v[0-9])?(?:$|[ ._\[(-])`),
+		animeOnly: true,
+	},
+	// ___.Part.2.___, ___.pt2.___
+	{
+		name: "part",
+		re: regexp.MustCompile(epPre + `(?i:part|pt)[ ._]?` +
+			`(?P<episode>[0-9]{1,2})` + epPost),
+	},
+	// ___.E04.___ in a season directory.
+	{
+		name: "Exx",
+		re: regexp.MustCompile(epPre + `(?i:e|ep|episode)[ ._]?` +
+			`(?P<episode>[0-9]{1,4})` + epPost),
+		needHint: true,
+	},
+	// ___.308.___ where the first number is the season.
+	{
+		name: "SNN",
+		re: regexp.MustCompile(`[ .](?P<season>[0-9]{1,2})` +
+			`(?P<episode>[0-9]{2})(?:$|[ .])`),
+		matchHint: true,
+		notYear: true,
+		notAnime: true,
+	},
+}
 
-// pattern: ___.308.___  (or 3x08) where first number is season.
-var pat4 = regexp.MustCompile(`^.*[ .]([0-9]{1,2})x?([0-9]{2})[ .].*$`)
+var epNumbers = regexp.MustCompile(`[0-9]+`)
 
 func parseInt(s string) (i int) {
 	n, err := strconv.ParseInt(s, 10, 64)
@@ -36,48 +125,173 @@ func parseFloat32(s string) (i float32) {
 	return
 }
 
-func parseEpisodeName(name string, seasonHint int, ep *Episode) (ok bool) {
-
-	ok = true
-
-	s := pat1.FindStringSubmatch(name)
-	if len(s) > 0 {
-		ep.Name = fmt.Sprintf("%sx%s", s[1], s[2])
-		ep.SeasonNo = parseInt(s[1])
-		ep.EpisodeNo = parseInt(s[2])
-		return
+// Compile the `episode-pattern' regexps of all collections.
+func initEpisodePatterns() (err error) {
+	for i := range config.Collections {
+		c := &(config.Collections[i])
+		c.episodePatterns = nil
+		for n, p := range c.EpisodePattern {
+			var re *regexp.Regexp
+			re, err = regexp.Compile(p)
+			if err != nil {
+				err = fmt.Errorf("collection %s: episode-pattern %s: %s",
+					c.Name_, p, err)
+				return
+			}
+			groups := map[string]bool{}
+			for _, g := range re.SubexpNames() {
+				groups[g] = true
+			}
+			if !groups["episode"] && !groups["episodes"] &&
+			   !groups["absolute"] &&
+			   !(groups["year"] && groups["month"] && groups["day"]) {
+				err = fmt.Errorf("collection %s: episode-pattern %s: " +
+					"needs an episode, episodes, absolute " +
+					"or year/month/day group", c.Name_, p)
+				return
+			}
+			c.episodePatterns = append(c.episodePatterns,
+				&episodePattern{
+					name: fmt.Sprintf("custom-%d", n + 1),
+					re: re,
+				})
+		}
 	}
+	return
+}
 
-	s = pat2.FindStringSubmatch(name)
-	if len(s) > 0 {
-		ep.Name = fmt.Sprintf("%sx%s-%s", s[1], s[2], s[3])
-		ep.SeasonNo = parseInt(s[1])
-		ep.EpisodeNo = parseInt(s[2])
-		ep.Double = true
+func (p *episodePattern) match(name string, seasonHint int) (info *EpisodeInfo) {
+	m := p.re.FindStringSubmatchIndex(name)
+	if m == nil {
 		return
 	}
-
-	s = pat3.FindStringSubmatch(name)
-	if len(s) > 0 {
-		ep.Name = s[1] + "." + s[2] + "." + s[3]
-		ep.SeasonNo = seasonHint
-		ep.EpisodeNo = parseInt(s[1] + s[2] + s[3])
+	if p.needHint && seasonHint < 0 {
 		return
 	}
-
-	s = pat4.FindStringSubmatch(name)
-	if len(s) > 0 {
-		sn := parseInt(s[1])
-		if seasonHint < 0 || seasonHint == sn {
-			ep.Name = fmt.Sprintf("%02dx%s", sn, s[2])
-			ep.SeasonNo = sn
-			ep.EpisodeNo = parseInt(s[2])
+	ei := &EpisodeInfo{
+		Pattern: p.name,
+		SeasonNo: -1,
+	}
+	var year, month, day int
+	for i, g := range p.re.SubexpNames() {
+		if g == "" || m[2*i] < 0 {
+			continue
+		}
+		v := name[m[2*i]:m[2*i+1]]
+		switch g {
+		case "show":
+			ei.Show, _, _ = cleanTitle(v)
+		case "season":
+			ei.SeasonNo = parseInt(v)
+		case "episode":
+			ei.EpisodeNo = parseInt(v)
+		case "episodes":
+			nums := epNumbers.FindAllString(v, -1)
+			if len(nums) == 0 {
+				return
+			}
+			ei.EpisodeNo = parseInt(nums[0])
+			if len(nums) > 1 {
+				ei.LastEpisodeNo = parseInt(nums[len(nums)-1])
+			}
+		case "absolute":
+			ei.AbsoluteNo = parseInt(v)
+		case "year":
+			year = parseInt(v)
+		case "month":
+			month = parseInt(v)
+		case "day":
+			day = parseInt(v)
+		}
+	}
+	if year > 0 && month > 0 && day > 0 {
+		if month > 12 || day > 31 {
+			return
 		}
+		ei.AirDate = fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	}
+	if p.notYear && ei.SeasonNo >= 19 && ei.SeasonNo <= 20 {
+		return
+	}
+	if p.matchHint && seasonHint >= 0 && ei.SeasonNo != seasonHint {
 		return
 	}
+	if ei.SeasonNo < 0 {
+		ei.SeasonNo = seasonHint
+	}
+	if ei.LastEpisodeNo <= ei.EpisodeNo {
+		ei.LastEpisodeNo = 0
+	}
 
-	ok = false
+	// everything before the match is the name of the show.
+	if ei.Show == "" && m[0] > 0 {
+		ei.Show, _, _ = cleanTitle(name[:m[0]])
+	}
+
+	info = ei
+	return
+}
+
+// Try the collection's own patterns first, then the builtin ones.
+func parseEpisodeInfo(coll *Collection, name string, seasonHint int) (info *EpisodeInfo) {
+	var patterns []*episodePattern
+	if coll != nil {
+		patterns = coll.episodePatterns
+	}
+	for _, p := range patterns {
+		if info = p.match(name, seasonHint); info != nil {
+			return
+		}
+	}
+	anime := coll != nil && coll.Type == "anime"
+	for _, p := range builtinEpisodePatterns {
+		if (p.animeOnly && !anime) || (p.notAnime && anime) {
+			continue
+		}
+		if info = p.match(name, seasonHint); info != nil {
+			return
+		}
+	}
 	return
 }
 
+func parseEpisodeName(coll *Collection, name string, seasonHint int, ep *Episode) (ok bool) {
 
+	info := parseEpisodeInfo(coll, name, seasonHint)
+	if info == nil {
+		return
+	}
+	ok = true
+
+	ep.SeasonNo = info.SeasonNo
+	ep.EpisodeNo = info.EpisodeNo
+	ep.LastEpisodeNo = info.LastEpisodeNo
+	ep.Double = info.LastEpisodeNo > 0
+	if ep.SeasonNo < 0 && info.Pattern == "part" {
+		ep.SeasonNo = 1
+	}
+
+	switch {
+	case info.AirDate != "":
+		// the year is the season. Episodes get numbered
+		// by date later, see numberDateEpisodes.
+		ep.Name = info.AirDate
+		ep.AirDate = info.AirDate
+		ep.SeasonNo = parseInt(info.AirDate[0:4])
+		ep.EpisodeNo = parseInt(info.AirDate[0:4] +
+				info.AirDate[5:7] + info.AirDate[8:10])
+	case info.AbsoluteNo > 0:
+		ep.Name = fmt.Sprintf("%02d", info.AbsoluteNo)
+		ep.AbsoluteNo = info.AbsoluteNo
+		ep.EpisodeNo = info.AbsoluteNo
+		if ep.SeasonNo < 0 {
+			ep.SeasonNo = 1
+		}
+	case ep.Double:
+		ep.Name = fmt.Sprintf("%02dx%02d-%02d", ep.SeasonNo,
+				ep.EpisodeNo, ep.LastEpisodeNo)
+	default:
+		ep.Name = fmt.Sprintf("%02dx%02d", ep.SeasonNo, ep.EpisodeNo)
+	}
+	return
+}
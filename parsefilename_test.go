@@ -0,0 +1,124 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseEpisodeInfo(t *testing.T) {
+	anime := &Collection{ Type: "anime" }
+	tests := []struct {
+		name		string
+		coll		*Collection
+		hint		int
+		want		*EpisodeInfo
+	}{
+		// SxxEyy
+		{ "Show.Name.S01E02.720p.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "SxxExx", Show: "Show Name", SeasonNo: 1, EpisodeNo: 2 } },
+		{ "show name s3e104.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "SxxExx", Show: "show name", SeasonNo: 3, EpisodeNo: 104 } },
+		// multi-episode
+		{ "Show.S01E02E03.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "SxxExx", Show: "Show", SeasonNo: 1, EpisodeNo: 2, LastEpisodeNo: 3 } },
+		{ "Show.S01E02-E04.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "SxxExx", Show: "Show", SeasonNo: 1, EpisodeNo: 2, LastEpisodeNo: 4 } },
+		{ "Show.3x08-09.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "NxNN", Show: "Show", SeasonNo: 3, EpisodeNo: 8, LastEpisodeNo: 9 } },
+		// 1x02
+		{ "Show 1x02.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "NxNN", Show: "Show", SeasonNo: 1, EpisodeNo: 2 } },
+		// dated
+		{ "Daily.Show.2015.03.08.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "date", Show: "Daily Show", SeasonNo: -1, AirDate: "2015-03-08" } },
+		{ "Daily.Show.2015.13.08.mp4", nil, -1, nil },
+		// absolute
+		{ "[Group] Some Anime - 123 [1080p].mkv", nil, -1,
+			&EpisodeInfo{ Pattern: "absolute", Show: "Some Anime", SeasonNo: -1, AbsoluteNo: 123 } },
+		{ "Some Anime - 07.mp4", anime, -1,
+			&EpisodeInfo{ Pattern: "absolute", Show: "Some Anime", SeasonNo: -1, AbsoluteNo: 7 } },
+		{ "Some Anime - 07.mp4", nil, -1, nil },
+		// part, Exx with a season hint, SNN
+		{ "Movie.Part.2.mp4", nil, -1,
+			&EpisodeInfo{ Pattern: "part", Show: "Movie", SeasonNo: -1, EpisodeNo: 2 } },
+		{ "Show.E04.mp4", nil, 2,
+			&EpisodeInfo{ Pattern: "Exx", Show: "Show", SeasonNo: 2, EpisodeNo: 4 } },
+		{ "Show.E04.mp4", nil, -1, nil },
+		{ "Show.308.mp4", nil, 3,
+			&EpisodeInfo{ Pattern: "SNN", Show: "Show", SeasonNo: 3, EpisodeNo: 8 } },
+		{ "Show.308.mp4", nil, 4, nil },
+		{ "Show.1999.mp4", nil, -1, nil },
+		// no match
+		{ "Just A Movie (2010).mp4", nil, -1, nil },
+		{ "", nil, -1, nil },
+	}
+	for _, tt := range tests {
+		got := parseEpisodeInfo(tt.coll, tt.name, tt.hint)
+		switch {
+		case got == nil && tt.want == nil:
+		case got == nil || tt.want == nil:
+			t.Errorf("%q: got %+v, want %+v", tt.name, got, tt.want)
+		case *got != *tt.want:
+			t.Errorf("%q: got %+v, want %+v", tt.name, *got, *tt.want)
+		}
+	}
+}
+
+func TestCustomEpisodePattern(t *testing.T) {
+	tests := []struct {
+		pattern		string
+		name		string
+		want		*EpisodeInfo
+	}{
+		{ `Episode (?P<episode>\d+)`, "Episode 12.mp4",
+			&EpisodeInfo{ Pattern: "custom", SeasonNo: -1, EpisodeNo: 12 } },
+		// an episodes group that matches without digits.
+		{ `x(?P<episodes>\d*)\.mp4`, "x.mp4", nil },
+		{ `(?P<episodes>E\d+|special)`, "special.mp4", nil },
+		{ `(?P<episodes>E\d+|special)`, "E05.mp4",
+			&EpisodeInfo{ Pattern: "custom", SeasonNo: -1, EpisodeNo: 5 } },
+	}
+	for _, tt := range tests {
+		p := &episodePattern{ name: "custom", re: regexp.MustCompile(tt.pattern) }
+		got := p.match(tt.name, -1)
+		switch {
+		case got == nil && tt.want == nil:
+		case got == nil || tt.want == nil:
+			t.Errorf("%s %q: got %+v, want %+v", tt.pattern, tt.name, got, tt.want)
+		case *got != *tt.want:
+			t.Errorf("%s %q: got %+v, want %+v", tt.pattern, tt.name, *got, *tt.want)
+		}
+	}
+}
+
+func TestInitEpisodePatterns(t *testing.T) {
+	saved := config.Collections
+	defer func() { config.Collections = saved }()
+
+	tests := []struct {
+		pattern		string
+		ok		bool
+	}{
+		{ `(?P<episode>\d+)`, true },
+		{ `(?P<episodes>\d+(?:-\d+)*)`, true },
+		{ `(?P<absolute>\d+)`, true },
+		{ `(?P<year>\d{4})-(?P<month>\d\d)-(?P<day>\d\d)`, true },
+		{ `(?P<day>\d\d)`, false },
+		{ `(?P<month>\d\d)-(?P<day>\d\d)`, false },
+		{ `(?P<season>\d+)`, false },
+		{ `(?P<episode>\d+`, false },
+	}
+	for _, tt := range tests {
+		config.Collections = []Collection{{
+			Name_: "test",
+			EpisodePattern: []string{ tt.pattern },
+		}}
+		err := initEpisodePatterns()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok %v", tt.pattern, err, tt.ok)
+		}
+		if err == nil && len(config.Collections[0].episodePatterns) != 1 {
+			t.Errorf("%s: pattern not added", tt.pattern)
+		}
+	}
+}
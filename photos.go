@@ -0,0 +1,249 @@
+// Collections of type "photos".
+//
+// Every directory with images in it is an album. The date a photo
+// was taken comes from EXIF, or from the file modification time.
+// cover.jpg or folder.jpg in a directory is the album cover, not a photo.
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var isPhoto = regexp.MustCompile(`(?i)^(.*)\.(jpg|jpeg|png)$`)
+
+type PhotoLibrary struct {
+	Albums		[]*PhotoAlbum
+	Photos		[]*Photo
+	LastModified	int64
+}
+
+type PhotoAlbum struct {
+	Id		string		`json:"id"`
+	Name		string		`json:"name"`
+	Path		string		`json:"path"`
+	BaseUrl		string		`json:"baseurl"`
+	Parent		string		`json:"parent,omitempty"`
+	Cover		string		`json:"cover,omitempty"`
+	First		string		`json:"first,omitempty"`
+	Last		string		`json:"last,omitempty"`
+	PhotoCount	int		`json:"photocount"`
+	Photos		[]*Photo	`json:"photos,omitempty"`
+}
+
+type Photo struct {
+	Id		string		`json:"id"`
+	Name		string		`json:"name"`
+	Path		string		`json:"path"`
+	BaseUrl		string		`json:"baseurl"`
+	AlbumId		string		`json:"albumid"`
+	Taken		string		`json:"taken"`
+	Camera		string		`json:"camera,omitempty"`
+	Lens		string		`json:"lens,omitempty"`
+	Orientation	int		`json:"orientation,omitempty"`
+	Width		int		`json:"width,omitempty"`
+	Height		int		`json:"height,omitempty"`
+	Gps		*GpsInfo	`json:"gps,omitempty"`
+
+	taken		time.Time
+	size		int64
+	modTime		int64
+}
+
+// One day, month or year of the timeline.
+type TimelineGroup struct {
+	Date		string		`json:"date"`
+	Count		int		`json:"count"`
+	Photos		[]*Photo	`json:"photos"`
+}
+
+func buildPhotos(coll *Collection, pace int) {
+	// EXIF of unchanged files is re-used from the previous scan.
+	prev := map[string]*Photo{}
+	if coll.photos != nil {
+		for _, p := range coll.photos.Photos {
+			prev[p.Path] = p
+		}
+	}
+
+	lib := &PhotoLibrary{}
+	ign := newIgnoreMatcher(coll)
+	buildPhotoAlbums(coll, ign, "", "", lib, prev, pace, 0)
+
+	sort.Slice(lib.Albums, func(i, j int) bool {
+		return lib.Albums[i].Path < lib.Albums[j].Path
+	})
+	for _, a := range lib.Albums {
+		lib.Photos = append(lib.Photos, a.Photos...)
+	}
+	sort.SliceStable(lib.Photos, func(i, j int) bool {
+		return lib.Photos[i].taken.Before(lib.Photos[j].taken)
+	})
+	for _, p := range lib.Photos {
+		if p.modTime > lib.LastModified {
+			lib.LastModified = p.modTime
+		}
+	}
+	coll.photos = lib
+}
+
+// Add the album in `dir', and the albums below it, to `lib'.
+func buildPhotoAlbums(coll *Collection, ign *ignoreMatcher, dir string, parent string, lib *PhotoLibrary, prev map[string]*Photo, pace int, depth int) {
+	d := path.Join(coll.Directory, dir)
+	f, err := OpenDir(d)
+	if err != nil {
+		coll.scanError("", d, "cannot read directory: %s", err)
+		return
+	}
+	fi, _ := f.Readdir(0)
+	f.Close()
+	ign = ign.sub(d)
+	fi = ign.filter(d, fi)
+
+	name := path.Base(dir)
+	if dir == "" {
+		name = coll.Name_
+	}
+	album := &PhotoAlbum{
+		Id: idHash(coll.Name_ + "/photos/" + dir),
+		Name: name,
+		Path: escapePath(dir),
+		BaseUrl: coll.BaseUrl,
+		Parent: parent,
+	}
+
+	var subdirs []string
+	for i := range fi {
+		fn := fi[i].Name()
+		if fi[i].IsDir() {
+			subdirs = append(subdirs, fn)
+			continue
+		}
+		s := isPhoto.FindStringSubmatch(fn)
+		if len(s) == 0 {
+			continue
+		}
+		switch strings.ToLower(s[1]) {
+		case "cover", "folder":
+			album.Cover = escapePath(fn)
+			continue
+		}
+		p := buildPhoto(coll, album, dir, &fi[i], prev)
+		if p != nil {
+			album.Photos = append(album.Photos, p)
+		}
+	}
+
+	if len(album.Photos) > 0 {
+		sort.SliceStable(album.Photos, func(i, j int) bool {
+			return album.Photos[i].taken.Before(album.Photos[j].taken)
+		})
+		album.PhotoCount = len(album.Photos)
+		album.First = album.Photos[0].Taken
+		album.Last = album.Photos[len(album.Photos) - 1].Taken
+		if album.Cover == "" {
+			album.Cover = escapePath(path.Base(unescapePath(album.Photos[0].Path)))
+		}
+		lib.Albums = append(lib.Albums, album)
+		parent = album.Id
+	}
+
+	if depth >= maxFolderDepth {
+		coll.diag(diagWarning, "", d, "directories nested too deep")
+		return
+	}
+	for _, sub := range subdirs {
+		if pace > 0 {
+			time.Sleep(time.Duration(int64(pace)) * time.Second)
+		}
+		buildPhotoAlbums(coll, ign, path.Join(dir, sub), parent,
+			lib, prev, pace, depth + 1)
+	}
+}
+
+func buildPhoto(coll *Collection, album *PhotoAlbum, dir string, fi *FileInfo, prev map[string]*Photo) (photo *Photo) {
+	name := fi.Name()
+	p := escapePath(path.Join(dir, name))
+	modTime := fi.Modtime().UnixMilli()
+	size := fi.Size()
+
+	if ph, ok := prev[p]; ok && ph.modTime == modTime && ph.size == size {
+		// a copy, the old one might still be being served.
+		ph2 := *ph
+		photo = &ph2
+		photo.AlbumId = album.Id
+		return
+	}
+
+	fn := path.Join(coll.Directory, dir, name)
+	var exif *ExifInfo
+	if f, err := vfsOpen(fn); err == nil {
+		exif = readExif(f)
+		f.Close()
+	}
+	if exif == nil {
+		coll.diag(diagWarning, album.Name, fn, "not a valid image")
+		return
+	}
+
+	taken := exif.Taken
+	if taken.IsZero() {
+		taken = fi.Modtime()
+	}
+	photo = &Photo{
+		Id: idHash(coll.Name_ + "/photos/" + path.Join(dir, name)),
+		Name: name,
+		Path: p,
+		BaseUrl: coll.BaseUrl,
+		AlbumId: album.Id,
+		Taken: taken.Format(time.RFC3339),
+		Camera: exif.Camera(),
+		Lens: exif.Lens,
+		Orientation: exif.Orientation,
+		Width: exif.Width,
+		Height: exif.Height,
+		Gps: exif.Gps,
+		taken: taken,
+		size: size,
+		modTime: modTime,
+	}
+	// width and height as displayed.
+	if exif.rotated() {
+		photo.Width, photo.Height = photo.Height, photo.Width
+	}
+	return
+}
+
+// Group photos by "day", "month" or "year", newest first.
+func photoTimeline(photos []*Photo, group string) (tl []TimelineGroup) {
+	layout := "2006-01"
+	switch group {
+	case "day":
+		layout = "2006-01-02"
+	case "year":
+		layout = "2006"
+	}
+	tl = []TimelineGroup{}
+	for i := len(photos) - 1; i >= 0; i-- {
+		date := photos[i].taken.Format(layout)
+		if len(tl) == 0 || tl[len(tl) - 1].Date != date {
+			tl = append(tl, TimelineGroup{ Date: date })
+		}
+		g := &tl[len(tl) - 1]
+		g.Photos = append(g.Photos, photos[i])
+		g.Count++
+	}
+	return
+}
+
+func getPhotoAlbum(lib *PhotoLibrary, id string) *PhotoAlbum {
+	for _, a := range lib.Albums {
+		if a.Id == id {
+			return a
+		}
+	}
+	return nil
+}
@@ -119,3 +119,72 @@ func hlsHandler(w http.ResponseWriter, r *http.Request) bool {
 
 	return true
 }
+
+// Streams can go on for hours, so no timeout on the whole request.
+var streamClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// Request headers that are passed on to the origin of a stream.
+var streamHeaders = []string{
+	"Accept",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"Range",
+	"User-Agent",
+}
+
+// A .strm file has the URL of a stream in it. Redirect the client
+// to it, or if `proxy' is set pass the stream through this server.
+func strmHandler(w http.ResponseWriter, r *http.Request, fn string, proxy bool) {
+	target := readStrm(fn)
+	u, err := url.Parse(target)
+	if target == "" || err != nil ||
+	   (u.Scheme != "http" && u.Scheme != "https") {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	if !proxy {
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	proxyStream(w, r, target)
+}
+
+// Pass a stream from `target' through to the client.
+func proxyStream(w http.ResponseWriter, r *http.Request, target string) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, nil)
+	if err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	for _, h := range streamHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		appendHostToXForwardHeader(req.Header, clientIP)
+	}
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		fmt.Printf("stream %s: %s\n", target, err)
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	delHopHeaders(resp.Header)
+	resp.Header.Del("Set-Cookie")
+	resp.Header.Del("Access-Control-Allow-Origin")
+	resp.Header.Del("Access-Control-Allow-Methods")
+	copyHeader(w.Header(), resp.Header)
+
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,210 @@
+// Collections of type "remote": a collection of another notflix-server.
+//
+// The items are fetched from the API of the peer and kept here, and
+// refreshed with conditional requests (the peer sends ETags). The
+// baseurl of the items is rewritten to point to this server, and
+// requests for /data/ are redirected to the peer, or proxied.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RemoteConfig struct {
+	Url		string
+	Collection	string
+	Proxy		bool
+}
+
+// State of a remote collection between refreshes.
+type remoteState struct {
+	typ		string
+	fetched		time.Time
+	listed		time.Time
+	etag		string
+	itemEtags	map[string]string
+}
+
+// Don't ask the peer more often than this.
+const remoteRefresh = 5 * time.Minute
+
+// The ETag of the list of items only changes with the newest item, so
+// once in a while the list is fetched unconditionally to see deletions.
+const remoteListRefresh = time.Hour
+
+func buildRemote(coll *Collection, pace int) {
+	rc := coll.Remote
+	if rc == nil || rc.Url == "" || rc.Collection == "" {
+		coll.diag(diagError, "", "", "remote collection needs a url and a collection")
+		return
+	}
+	st := coll.remote
+	if st == nil {
+		st = &remoteState{ itemEtags: map[string]string{} }
+		coll.remote = st
+	}
+	if pace > 0 && time.Since(st.fetched) < remoteRefresh {
+		return
+	}
+	st.fetched = time.Now()
+	base := strings.TrimSuffix(rc.Url, "/") + "/api/collection/" +
+		url.PathEscape(rc.Collection)
+
+	var rcoll Collection
+	if _, _, err := remoteGet(base, "", &rcoll); err != nil {
+		coll.scanError("", base, "cannot fetch collection: %s", err)
+		return
+	}
+	st.typ = rcoll.Type
+
+	var items []*Item
+	listEtag := st.etag
+	if time.Since(st.listed) >= remoteListRefresh {
+		listEtag = ""
+	}
+	etag, _, err := remoteGet(base + "/items", listEtag, &items)
+	if err != nil {
+		coll.scanError("", base + "/items", "cannot fetch items: %s", err)
+		return
+	}
+	if items == nil {
+		// not modified, but older items might have been.
+		items = coll.Items
+	} else {
+		st.listed = time.Now()
+	}
+
+	prev := map[string]*Item{}
+	for _, item := range coll.Items {
+		prev[item.Id] = item
+	}
+	etags := map[string]string{}
+	byId := map[string]*Item{}
+	for _, item := range items {
+		if pace > 0 {
+			time.Sleep(time.Duration(int64(pace)) * time.Second)
+		}
+		u := base + "/item/" + url.PathEscape(item.Id)
+		var detail *Item
+		ietag, lastMod, err := remoteGet(u, st.itemEtags[item.Id], &detail)
+		if err != nil {
+			coll.diag(diagWarning, item.Name, u, "cannot fetch item: %s", err)
+		}
+		switch {
+		case detail != nil:
+			detail.BaseUrl = remoteBaseUrl(coll, detail.BaseUrl)
+			// so that the ETag of our item changes when theirs does.
+			if lastMod.IsZero() {
+				lastMod = time.Now()
+			}
+			detail.NfoTime = lastMod.UnixMilli()
+		case prev[item.Id] != nil:
+			// not modified, or not available right now.
+			item2 := *prev[item.Id]
+			detail = &item2
+			ietag = st.itemEtags[item.Id]
+		default:
+			detail = item
+			detail.BaseUrl = remoteBaseUrl(coll, detail.BaseUrl)
+			ietag = ""
+		}
+		detail.Children = nil
+		etags[item.Id] = ietag
+		byId[item.Id] = detail
+	}
+
+	collItems := make([]*Item, 0, len(items))
+	for _, item := range items {
+		detail := byId[item.Id]
+		if p, ok := byId[detail.Parent]; ok {
+			p.Children = append(p.Children, detail)
+		}
+		collItems = append(collItems, detail)
+	}
+	coll.Items = collItems
+	st.etag = etag
+	st.itemEtags = etags
+}
+
+// GET a JSON object from the peer. If `etag' is set, it is sent as
+// If-None-Match; if the object was not modified, `obj' is left alone.
+func remoteGet(u string, etag string, obj interface{}) (newEtag string, lastMod time.Time, err error) {
+	newEtag = etag
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return
+	case http.StatusOK:
+	default:
+		err = fmt.Errorf("%s", resp.Status)
+		return
+	}
+	newEtag = resp.Header.Get("ETag")
+	lastMod, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	err = json.NewDecoder(resp.Body).Decode(obj)
+	return
+}
+
+// "/data/3" on the peer becomes "/data/<our source id>/3" here.
+func remoteBaseUrl(coll *Collection, baseUrl string) string {
+	return coll.BaseUrl + "/" + strings.TrimPrefix(baseUrl, "/data/")
+}
+
+// The type as shown in the API: that of the collection on the peer.
+func (coll *Collection) apiType() string {
+	if coll.Type == "remote" && coll.remote != nil && coll.remote.typ != "" {
+		return coll.remote.typ
+	}
+	return coll.Type
+}
+
+func getRemoteCollection(source string) (c *Collection) {
+	id, err := strconv.ParseInt(source, 10, 64)
+	if err != nil {
+		return
+	}
+	for n := range config.Collections {
+		c = &(config.Collections[n])
+		if int64(c.SourceId) == id && c.Type == "remote" {
+			return
+		}
+	}
+	c = nil
+	return
+}
+
+// /data/ of a remote collection: redirect to the peer, or proxy.
+func remoteDataHandler(w http.ResponseWriter, r *http.Request, coll *Collection, p string) {
+	if coll.Remote == nil || coll.Remote.Url == "" {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	u, err := buildUrl(strings.TrimSuffix(coll.Remote.Url, "/") + "/data/", p)
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	u.RawQuery = r.URL.RawQuery
+	if !coll.Remote.Proxy {
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+	proxyStream(w, r, u.String())
+}
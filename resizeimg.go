@@ -3,11 +3,13 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"gopkg.in/gographics/imagick.v2/imagick"
@@ -16,7 +18,7 @@ import (
 var resizeMutexMap = make(map[string]*sync.Mutex)
 var resizeMutexMapLock sync.Mutex
 
-var isImg = regexp.MustCompile(`\.(png|jpg|jpeg|tbn)$`)
+var isImg = regexp.MustCompile(`(?i)\.(png|jpg|jpeg|tbn)$`)
 var tmpExt = ".tmp"
 
 func resizeimg_init() {
@@ -164,7 +166,7 @@ func OpenFile(rw http.ResponseWriter, rq *http.Request, name string) (file http.
 	if len(s) == 0 {
 		return
 	}
-	ctype := s[1]
+	ctype := strings.ToLower(s[1])
 	if ctype == "tbn" || ctype == "jpeg" {
 		ctype = "jpg"
 	}
@@ -219,6 +221,18 @@ func OpenFile(rw http.ResponseWriter, rq *http.Request, name string) (file http.
 		cacheWriteInfo(file, ow, oh)
 	}
 
+	// photos are often stored sideways, with the
+	// orientation of the camera in the EXIF data.
+	orientation := 0
+	if ra, ok := file.(io.ReaderAt); ok && ctype == "jpg" {
+		if exif := readExif(ra); exif != nil {
+			orientation = exif.Orientation
+			if exif.rotated() {
+				ow, oh = oh, ow
+			}
+		}
+	}
+
 	// if we do not have both wanted width and height,
 	// we need to calculate them.
 	if w == 0 || h == 0 {
@@ -259,7 +273,8 @@ func OpenFile(rw http.ResponseWriter, rq *http.Request, name string) (file http.
 
 	// image could be the right size and quality already.
 	need_resize := uint(ow) != uint(w) || uint(oh) != uint(h)
-	if !need_resize && q == 0 {
+	need_rotate := orientation > 1
+	if !need_resize && !need_rotate && q == 0 {
 		return
 	}
 
@@ -288,6 +303,14 @@ func OpenFile(rw http.ResponseWriter, rq *http.Request, name string) (file http.
 		return
 	}
 
+	// rotate, this also resets the orientation in the EXIF data.
+	if need_rotate {
+		err = wand.AutoOrientImage()
+		if err != nil {
+			return
+		}
+	}
+
 	// resize.
 	if need_resize {
 		// err = wand.ResizeImage(uint(w), uint(h),
@@ -0,0 +1,473 @@
+// Collections in S3-compatible object storage (AWS, MinIO, ...).
+//
+// The directory of such a collection is s3://bucket/prefix. At the
+// start of every scan the objects under the prefix are listed, and
+// the keys are turned into a directory tree. The scanner reads what
+// it needs (NFO files, headers of videos) with range requests.
+// Requests for /data/ are redirected to a presigned URL, or the
+// object is streamed through this server.
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	Endpoint	string
+	Region		string
+	AccessKey	string		`cc:"access-key"`
+	SecretKey	string		`cc:"secret-key"`
+	Presign		bool
+}
+
+type s3Client struct {
+	endpoint	*url.URL
+	region		string
+	accessKey	string
+	secretKey	string
+	bucket		string
+	presign		bool
+}
+
+// The tree of objects, and where it came from.
+type s3FS struct {
+	*MemFS
+	client		*s3Client
+	prefix		string
+}
+
+// An object, read with range requests.
+type s3Object struct {
+	client		*s3Client
+	key		string
+	size		int64
+}
+
+// A buffered reader for an open object, so that small reads
+// do not all turn into requests.
+type s3Reader struct {
+	obj		*s3Object
+	buf		[]byte
+	bufOff		int64
+}
+
+const s3BlockSize = 64 * 1024
+const s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+const s3PresignExpiry = 6 * time.Hour
+
+type s3ListResult struct {
+	IsTruncated		bool
+	NextContinuationToken	string
+	Contents		[]struct {
+		Key		string
+		LastModified	time.Time
+		Size		int64
+	}
+}
+
+func newS3Client(cfg *S3Config, bucket string) (c *s3Client, err error) {
+	if cfg == nil {
+		cfg = &S3Config{}
+	}
+	c = &s3Client{
+		region: cfg.Region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		bucket: bucket,
+		presign: cfg.Presign,
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	if c.accessKey == "" {
+		c.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if c.secretKey == "" {
+		c.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + c.region + ".amazonaws.com"
+	}
+	c.endpoint, err = url.Parse(endpoint)
+	if err == nil && c.endpoint.Host == "" {
+		err = fmt.Errorf("%s: not a valid endpoint", endpoint)
+	}
+	return
+}
+
+// Mount a collection with an s3:// directory. The objects are listed
+// again on every scan.
+func (coll *Collection) mountS3() (err error) {
+	u, err := url.Parse(coll.Directory)
+	if err != nil {
+		return
+	}
+	if u.Host == "" {
+		err = fmt.Errorf("%s: no bucket", coll.Directory)
+		return
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	client, err := newS3Client(coll.S3, u.Host)
+	if err != nil {
+		return
+	}
+	fsys, err := client.listFS(prefix)
+	if err != nil {
+		return
+	}
+	mountFS(coll.Directory, fsys)
+	return
+}
+
+// List all objects under `prefix', and build a tree from the keys.
+func (c *s3Client) listFS(prefix string) (fsys *s3FS, err error) {
+	fsys = &s3FS{ MemFS: NewMemFS(), client: c, prefix: prefix }
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, e := c.request(context.Background(), "GET", "", q)
+		if e != nil {
+			err = e
+			return
+		}
+		c.sign(req, time.Now())
+		resp, e := netClient.Do(req)
+		if e != nil {
+			err = e
+			return
+		}
+		var res s3ListResult
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error(resp)
+		} else {
+			err = xml.NewDecoder(resp.Body).Decode(&res)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return
+		}
+
+		for _, o := range res.Contents {
+			name := strings.TrimPrefix(o.Key, prefix)
+			dir := strings.HasSuffix(name, "/")
+			name = strings.TrimSuffix(name, "/")
+			if name == "" || !fs.ValidPath(name) {
+				continue
+			}
+			if dir {
+				// a "directory marker" object.
+				fsys.addNode(name, &memNode{ mode: fs.ModeDir | 0755,
+					modtime: o.LastModified, createtime: o.LastModified })
+				continue
+			}
+			fsys.addNode(name, &memNode{
+				mode: 0644,
+				modtime: o.LastModified,
+				createtime: o.LastModified,
+				size: o.Size,
+				data: &s3Object{ client: c, key: o.Key, size: o.Size },
+			})
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+	return
+}
+
+// New unsigned request for `key' in the bucket.
+func (c *s3Client) request(ctx context.Context, method string, key string, q url.Values) (req *http.Request, err error) {
+	u := *c.endpoint
+	u.Path = "/" + c.bucket + "/" + key
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(q)
+	req, err = http.NewRequestWithContext(ctx, method, u.String(), nil)
+	return
+}
+
+// Sign a request (AWS signature version 4). Requests never have a body.
+func (c *s3Client) sign(req *http.Request, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", s3EmptyHash)
+
+	hdrs := map[string]string{ "host": req.URL.Host }
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			hdrs[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	var names []string
+	for k := range hdrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonHdrs := ""
+	for _, k := range names {
+		canonHdrs += k + ":" + hdrs[k] + "\n"
+	}
+	signed := strings.Join(names, ";")
+
+	creq := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		s3Query(req.URL.Query()),
+		canonHdrs,
+		signed,
+		s3EmptyHash,
+	}, "\n")
+	scope, sig := c.signature(t, creq)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signed, sig))
+}
+
+// Presigned URL for a GET of `key'.
+func (c *s3Client) presignURL(key string, expires time.Duration, t time.Time) string {
+	u := *c.endpoint
+	u.Path = "/" + c.bucket + "/" + key
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", c.accessKey + "/" + c.scope(t))
+	q.Set("X-Amz-Date", t.UTC().Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires / time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	creq := strings.Join([]string{
+		"GET",
+		s3Escape(u.Path, false),
+		s3Query(q),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	_, sig := c.signature(t, creq)
+	q.Set("X-Amz-Signature", sig)
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(q)
+	return u.String()
+}
+
+func (c *s3Client) scope(t time.Time) string {
+	return t.UTC().Format("20060102") + "/" + c.region + "/s3/aws4_request"
+}
+
+func (c *s3Client) signature(t time.Time, creq string) (scope string, sig string) {
+	scope = c.scope(t)
+	h := sha256.Sum256([]byte(creq))
+	sts := "AWS4-HMAC-SHA256\n" + t.UTC().Format("20060102T150405Z") + "\n" +
+		scope + "\n" + hex.EncodeToString(h[:])
+	key := []byte("AWS4" + c.secretKey)
+	for _, s := range []string{ t.UTC().Format("20060102"), c.region, "s3", "aws4_request" } {
+		key = hmacSHA256(key, s)
+	}
+	sig = hex.EncodeToString(hmacSHA256(key, sts))
+	return
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// URI encoding as S3 wants it: everything but the unreserved
+// characters, and the slash in paths.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') ||
+		   (c >= '0' && c <= '9') || c == '-' || c == '_' ||
+		   c == '.' || c == '~' || (c == '/' && !slash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Canonical query string: sorted by name, everything escaped.
+func s3Query(q url.Values) string {
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string{}, q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k, true) + "=" + s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Error(resp *http.Response) error {
+	var e struct {
+		Code	string
+		Message	string
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64 * 1024))
+	if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+	}
+	return fmt.Errorf("s3: %s", resp.Status)
+}
+
+// Read a range of the object.
+func (o *s3Object) ReadAt(b []byte, off int64) (n int, err error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(b))
+	if end > o.size {
+		end = o.size
+	}
+	req, err := o.client.request(context.Background(), "GET", o.key, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end - 1))
+	o.client.sign(req, time.Now())
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		err = s3Error(resp)
+		return
+	}
+	n, err = io.ReadFull(resp.Body, b[:end - off])
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return
+}
+
+// MemFS gives every open file its own reader.
+func (o *s3Object) newReader() io.ReaderAt {
+	return &s3Reader{ obj: o }
+}
+
+func (r *s3Reader) ReadAt(b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		if off < r.bufOff || off >= r.bufOff + int64(len(r.buf)) {
+			if off >= r.obj.size {
+				err = io.EOF
+				return
+			}
+			size := len(b) - n
+			if size < s3BlockSize {
+				size = s3BlockSize
+			}
+			if int64(size) > r.obj.size - off {
+				size = int(r.obj.size - off)
+			}
+			buf := make([]byte, size)
+			if _, err = r.obj.ReadAt(buf, off); err != nil && err != io.EOF {
+				return
+			}
+			err = nil
+			r.buf, r.bufOff = buf, off
+		}
+		c := copy(b[n:], r.buf[off - r.bufOff:])
+		n += c
+		off += int64(c)
+	}
+	return
+}
+
+// Serve an object for /data/: redirect to it, or pass it through.
+func (s *s3FS) serveObject(w http.ResponseWriter, r *http.Request, rel string) {
+	fi, err := s.Stat(rel)
+	if err != nil || !fi.Mode().IsRegular() {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	key := s.prefix + rel
+	if s.client.presign {
+		http.Redirect(w, r, s.client.presignURL(key, s3PresignExpiry, time.Now()),
+			http.StatusFound)
+		return
+	}
+
+	method := "GET"
+	if r.Method == "HEAD" {
+		method = "HEAD"
+	}
+	req, err := s.client.request(r.Context(), method, key, nil)
+	if err != nil {
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+	for _, h := range streamHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		appendHostToXForwardHeader(req.Header, clientIP)
+	}
+	s.client.sign(req, time.Now())
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		fmt.Printf("s3 %s: %s\n", key, err)
+		http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	delHopHeaders(resp.Header)
+	for k := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+			resp.Header.Del(k)
+		}
+	}
+	resp.Header.Del("Server")
+	resp.Header.Del("Set-Cookie")
+	copyHeader(w.Header(), resp.Header)
+
+	// objects are often stored as binary/octet-stream.
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(rel)), ".")
+	if ct, ok := audioTypes[ext]; ok {
+		w.Header().Set("Content-Type", ct)
+	} else if ct := mime.TypeByExtension("." + ext); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("cache-control", "max-age=86400, stale-while-revalidate=300");
+
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/handlers"
@@ -26,11 +27,15 @@ type cfgMain struct {
 	Cachedir	string
 	Dbdir		string
 	Logfile		string
+	AdminToken	string		`cc:"admin-token"`
+	DeletedRetention int		`cc:"deleted-retention"`
 	Collections	[]Collection `cc:"collection"`
 }
+
 var config = cfgMain{
 	Listen:		"127.0.0.1:8060",
 	Logfile:	"stdout",
+	DeletedRetention: 30,
 }
 
 func dataHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,6 +47,10 @@ func dataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	vars := mux.Vars(r)
+	if c := getRemoteCollection(vars["source"]); c != nil {
+		remoteDataHandler(w, r, c, vars["path"])
+		return
+	}
 	dataDir := getDataDir(vars["source"])
 	if dataDir == "" {
 		http.Error(w, "404 Not Found", http.StatusNotFound)
@@ -58,16 +67,32 @@ func dataHandler(w http.ResponseWriter, r *http.Request) {
 	if i >= 0 {
 		ext = fn[i+1:]
 	}
-	if ext == "srt" || ext == "vtt" {
+	if ext == "strm" {
+		strmHandler(w, r, fn, getStrmProxy(vars["source"]))
+		return
+	}
+	fsys, rel := lookupFS(fn)
+	if s3, ok := fsys.(*s3FS); ok {
+		s3.serveObject(w, r, rel)
+		return
+	}
+	switch {
+	case isMounted(fn):
+		// archives: no image resizing or subtitle conversion.
+		file, err = http.FS(fsys).Open(rel)
+	case ext == "srt" || ext == "vtt":
 		file, err = OpenSub(w, r, fn)
-	} else {
+	default:
 		file, err = OpenFile(w, r, fn)
 	}
-	defer file.Close()
 	if err != nil {
+		if file != nil {
+			file.Close()
+		}
 		http.Error(w, "404 Not Found", http.StatusNotFound)
 		return
 	}
+	defer file.Close()
 
 	fi , _ := file.Stat()
 	if !fi.Mode().IsRegular() {
@@ -75,6 +100,9 @@ func dataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if ct, ok := audioTypes[strings.ToLower(ext)]; ok {
+		w.Header().Set("Content-Type", ct)
+	}
 	w.Header().Set("cache-control", "max-age=86400, stale-while-revalidate=300");
 	if (checkEtag(w, r, file)) {
 		return
@@ -87,9 +115,19 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, path.Join(config.Appdir, "index.html"))
 }
 
+// A scan of all collections at most this often.
+const scanInterval = time.Minute
+
 func backgroundTasks() {
 	for {
+		start := time.Now()
 		updateCollections(1)
+		if err := dbPurgeItems(); err != nil {
+			fmt.Printf("purge deleted items: %s\n", err)
+		}
+		if d := time.Since(start); d < scanInterval {
+			time.Sleep(scanInterval - d)
+		}
 	}
 }
 
@@ -113,8 +151,19 @@ func main() {
 	logfile := flag.String("logfile", config.Logfile,
 		"Path of logfile. Use 'syslog' for syslog, 'stdout' " +
 		"for standard output, or 'none' to disable logging.")
+	report := flag.String("report", "",
+		"Print a report and exit. Reports: 'missing' (episodes), " +
+		"'health' (scan problems).")
+	reportColl := flag.String("collection", "",
+		"Only report on this collection.")
 	flag.Parse()
 
+	err = initEpisodePatterns()
+	if err != nil {
+		log.Fatalf("%s\n", err)
+		return
+	}
+
 	log.Printf("dbinit")
 
 	err = dbInit(path.Join(config.Dbdir, "tink-items.db"))
@@ -123,6 +172,18 @@ func main() {
 		return
 	}
 
+	switch *report {
+	case "":
+	case "missing":
+		missingReportCLI(os.Stdout, *reportColl)
+		return
+	case "health":
+		healthReportCLI(os.Stdout, *reportColl)
+		return
+	default:
+		log.Fatalf("unknown report %s\n", *report)
+	}
+
 	log.Printf("setting logfile")
 
 	switch *logfile {
@@ -157,10 +218,43 @@ func main() {
 	s.HandleFunc("/collections", collectionsHandler)
 	s.HandleFunc("/collection/{coll}", collectionHandler)
 	s.HandleFunc("/collection/{coll}/genres", genresHandler)
+	s.HandleFunc("/collection/{coll}/parse", parseHandler)
+	s.HandleFunc("/collection/{coll}/missing", collectionMissingHandler)
+	s.HandleFunc("/parse", parseHandler)
 	s.Handle("/collection/{coll}/items",
 			gzip(http.HandlerFunc(itemsHandler)))
+	s.HandleFunc("/collection/{coll}/item/{item}",
+			itemPatchHandler).Methods("PATCH")
 	s.Handle("/collection/{coll}/item/{item}",
 			gzip(http.HandlerFunc(itemHandler)))
+	s.HandleFunc("/collection/{coll}/item/{item}/season/{season}",
+			seasonHandler)
+	s.HandleFunc("/collection/{coll}/item/{item}/season/{season}/episode/{episode}",
+			episodePatchHandler)
+	s.HandleFunc("/collection/{coll}/item/{item}/episode/{episode}",
+			episodeHandler)
+	s.HandleFunc("/collection/{coll}/item/{item}/date/{date}",
+			episodeByDateHandler)
+	s.HandleFunc("/collection/{coll}/item/{item}/missing",
+			itemMissingHandler)
+
+	s.Handle("/collection/{coll}/artists",
+			gzip(http.HandlerFunc(artistsHandler)))
+	s.HandleFunc("/collection/{coll}/artist/{artist}", artistHandler)
+	s.Handle("/collection/{coll}/albums",
+			gzip(http.HandlerFunc(albumsHandler)))
+	s.Handle("/collection/{coll}/album/{album}",
+			gzip(http.HandlerFunc(albumHandler)))
+	s.Handle("/collection/{coll}/tracks",
+			gzip(http.HandlerFunc(tracksHandler)))
+	s.Handle("/collection/{coll}/timeline",
+			gzip(http.HandlerFunc(timelineHandler)))
+
+	s.HandleFunc("/admin/health", healthHandler)
+	s.HandleFunc("/admin/health/{coll}", collectionHealthHandler)
+	s.HandleFunc("/admin/deleted", deletedHandler)
+	s.HandleFunc("/admin/deleted/{coll}", collectionDeletedHandler)
+	s.HandleFunc("/admin/deleted/{coll}/{item}/restore", restoreHandler)
 
 	r.Handle("/data", notFound)
 	s = r.PathPrefix("/data/").Subrouter()
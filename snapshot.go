@@ -0,0 +1,164 @@
+// Snapshots of the scanned collections in the database.
+//
+// Scanning a big library (over NFS, say) takes minutes. At startup
+// the collections are loaded from their last snapshot instead, and
+// the background scan brings them up to date.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type snapshot struct {
+	Type		string
+	Directory	string
+	BaseUrl		string
+	Items		[]*Item
+	// ids of the children of Items[i], in order.
+	Children	[][]string
+}
+
+// Hash of the last snapshot of each collection, so that a collection
+// that did not change is not stored again. Not set by dbLoadSnapshot:
+// the first scan after startup stores everything, in case the tables
+// are behind (a migration emptied them, say).
+var snapshotHashes = map[string][sha256.Size]byte{}
+
+// Only collections made of items. Music and photo collections
+// are scanned at startup.
+func hasSnapshot(coll *Collection) bool {
+	switch coll.Type {
+	case "movies", "shows", "anime", "folder", "homevideos", "remote":
+		return true
+	}
+	return false
+}
+
+// Hash of a snapshot, with the unique ids hashed apart (see itemHash).
+func snapshotHash(snap *snapshot) (sum [sha256.Size]byte, err error) {
+	ids := make([]map[string]string, len(snap.Items))
+	for i, item := range snap.Items {
+		ids[i] = item.UniqueIds
+		item.UniqueIds = nil
+	}
+	h := sha256.New()
+	err = gob.NewEncoder(h).Encode(snap)
+	for i, item := range snap.Items {
+		item.UniqueIds = ids[i]
+		hashUniqueIds(h, ids[i])
+	}
+	copy(sum[:], h.Sum(nil))
+	return
+}
+
+// If the items of `coll' changed, store them in the tables (see
+// dbstore.go) and save a snapshot.
+func dbSaveSnapshot(coll *Collection) (err error) {
+	if dbHandle == nil || !hasSnapshot(coll) {
+		return
+	}
+	snap := snapshot{
+		Type: coll.Type,
+		Directory: coll.Directory,
+		BaseUrl: coll.BaseUrl,
+		Items: make([]*Item, len(coll.Items)),
+		Children: make([][]string, len(coll.Items)),
+	}
+	for i, item := range coll.Items {
+		it := *item
+		it.Children = nil
+		for _, c := range item.Children {
+			snap.Children[i] = append(snap.Children[i], c.Id)
+		}
+		snap.Items[i] = &it
+	}
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(&snap)
+	if err != nil {
+		return
+	}
+	sum, err := snapshotHash(&snap)
+	if err != nil || sum == snapshotHashes[coll.Name_] {
+		return
+	}
+
+	tx, err := dbHandle.Beginx()
+	if err != nil {
+		return
+	}
+	err = dbStoreItems(tx, coll)
+	if err == nil {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO snapshots(collection, ` +
+			`scanned, data) VALUES (?, ?, ?)`,
+			coll.Name_, time.Now().UnixMilli(), buf.Bytes())
+	}
+	if err != nil {
+		tx.Rollback()
+		// store everything again next time.
+		storedItems = map[string][sha256.Size]byte{}
+		return
+	}
+	err = tx.Commit()
+	if err == nil {
+		snapshotHashes[coll.Name_] = sum
+	}
+	return
+}
+
+// Load the items of `coll' from its snapshot. Returns false if there
+// is no snapshot, or it is of a different type or directory.
+func dbLoadSnapshot(coll *Collection) (ok bool, err error) {
+	if dbHandle == nil || !hasSnapshot(coll) {
+		return
+	}
+	var data []byte
+	err = dbHandle.Get(&data, "SELECT data FROM snapshots WHERE collection = ?",
+		coll.Name_)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	var snap snapshot
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&snap)
+	if err != nil {
+		err = fmt.Errorf("snapshot %s: %s", coll.Name_, err)
+		return
+	}
+	if snap.Type != coll.Type || snap.Directory != coll.Directory ||
+	   len(snap.Children) != len(snap.Items) {
+		return
+	}
+
+	byId := map[string]*Item{}
+	for _, item := range snap.Items {
+		// the collection might have another source id now.
+		if snap.BaseUrl != coll.BaseUrl &&
+		   strings.HasPrefix(item.BaseUrl, snap.BaseUrl) {
+			item.BaseUrl = coll.BaseUrl + item.BaseUrl[len(snap.BaseUrl):]
+		}
+		// snapshots from before seasons and episodes had ids.
+		if item.Type == "show" && coll.Type != "remote" {
+			item.setEpisodeIds()
+		}
+		byId[item.Id] = item
+	}
+	for i, item := range snap.Items {
+		for _, id := range snap.Children[i] {
+			if c, found := byId[id]; found {
+				item.Children = append(item.Children, c)
+			}
+		}
+	}
+	coll.Items = snap.Items
+	ok = true
+	return
+}
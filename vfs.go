@@ -0,0 +1,328 @@
+// All file access of the library scanner goes through here.
+//
+// Names are the same paths as always (coll.Directory/...). Most
+// collections are plain directories, and those names go to the OS.
+// A collection can also be mounted on another fs.FS: a zip or tar
+// archive (directory /media/movies.zip), a bucket in object storage
+// (s3://bucket/prefix, see s3.go), or an in-memory tree.
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An open file. Files can always seek and be read at an offset,
+// files that do not support that themselves are read into memory.
+type File interface {
+	fs.File
+	io.Seeker
+	io.ReaderAt
+}
+
+// max. size of a file that is read into memory.
+const maxMemFile = 256 << 20
+
+type vfsMount struct {
+	dir		string
+	fsys		fs.FS
+	closer		io.Closer
+	modtime		time.Time
+}
+
+var vfsMounts = map[string]*vfsMount{}
+var vfsMountsLock sync.RWMutex
+
+// The OS filesystem, with names used as they are.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// Mount `fsys' on directory `dir'.
+func mountFS(dir string, fsys fs.FS) {
+	dir = path.Clean(dir)
+	vfsMountsLock.Lock()
+	vfsMounts[dir] = &vfsMount{ dir: dir, fsys: fsys }
+	vfsMountsLock.Unlock()
+}
+
+// Find the filesystem that `name' is on, and the name relative to it.
+func lookupFS(name string) (fsys fs.FS, rel string) {
+	name = path.Clean(name)
+	vfsMountsLock.RLock()
+	defer vfsMountsLock.RUnlock()
+	var m *vfsMount
+	for dir, mnt := range vfsMounts {
+		if name != dir && !strings.HasPrefix(name, dir + "/") {
+			continue
+		}
+		if m == nil || len(dir) > len(m.dir) {
+			m = mnt
+		}
+	}
+	if m == nil {
+		return osFS{}, name
+	}
+	rel = strings.TrimPrefix(strings.TrimPrefix(name, m.dir), "/")
+	if rel == "" {
+		rel = "."
+	}
+	return m.fsys, rel
+}
+
+// Is `name' on a mounted filesystem instead of the OS.
+func isMounted(name string) bool {
+	fsys, _ := lookupFS(name)
+	_, isOS := fsys.(osFS)
+	return !isOS
+}
+
+func vfsStat(name string) (fs.FileInfo, error) {
+	fsys, rel := lookupFS(name)
+	return fs.Stat(fsys, rel)
+}
+
+func vfsReadDir(name string) ([]fs.DirEntry, error) {
+	fsys, rel := lookupFS(name)
+	return fs.ReadDir(fsys, rel)
+}
+
+func vfsOpen(name string) (file File, err error) {
+	fsys, rel := lookupFS(name)
+	f, err := fsys.Open(rel)
+	if err != nil {
+		return
+	}
+	if file, ok := f.(File); ok {
+		return file, nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if fi.Size() > maxMemFile {
+		err = &fs.PathError{ Op: "open", Path: name,
+			Err: fmt.Errorf("cannot seek, and too big to read into memory") }
+		return
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return
+	}
+	file = &bytesFile{ Reader: bytes.NewReader(b), fi: fi }
+	return
+}
+
+type bytesFile struct {
+	*bytes.Reader
+	fi		fs.FileInfo
+}
+
+func (f *bytesFile) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *bytesFile) Close() error {
+	return nil
+}
+
+// Mount a collection that is an archive. Nothing to do for
+// directories, or for collections that were mounted already.
+// Archives are opened again when they change.
+func (coll *Collection) mount() (err error) {
+	var kind string
+	switch {
+	case strings.HasPrefix(coll.Directory, "s3://"):
+		return coll.mountS3()
+	case strings.HasSuffix(coll.Directory, ".zip"):
+		kind = "zip"
+	case strings.HasSuffix(coll.Directory, ".tar"):
+		kind = "tar"
+	default:
+		return
+	}
+	dir := path.Clean(coll.Directory)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return
+	}
+
+	vfsMountsLock.RLock()
+	old := vfsMounts[dir]
+	vfsMountsLock.RUnlock()
+	if old != nil && old.modtime.Equal(fi.ModTime()) {
+		return
+	}
+
+	var fsys *MemFS
+	var closer io.Closer
+	if kind == "zip" {
+		fsys, closer, err = openZipFS(dir)
+	} else {
+		fsys, closer, err = openTarFS(dir)
+	}
+	if err != nil {
+		return
+	}
+	vfsMountsLock.Lock()
+	vfsMounts[dir] = &vfsMount{
+		dir: dir,
+		fsys: fsys,
+		closer: closer,
+		modtime: fi.ModTime(),
+	}
+	vfsMountsLock.Unlock()
+
+	// files of the old archive might still be being served.
+	if old != nil && old.closer != nil {
+		go func() {
+			time.Sleep(time.Hour)
+			old.closer.Close()
+		}()
+	}
+	return
+}
+
+// Zip archives. Stored files are read straight from the archive,
+// compressed files are unpacked into memory every time they are
+// opened, and that memory is freed when the file is closed.
+func openZipFS(fn string) (fsys *MemFS, closer io.Closer, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return
+	}
+	fsys = NewMemFS()
+	for _, zf := range zr.File {
+		zf := zf
+		fi := zf.FileInfo()
+		if fi.IsDir() {
+			fsys.addNode(zf.Name, &memNode{ mode: fs.ModeDir | 0755,
+				modtime: zf.Modified, createtime: zf.Modified })
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		n := &memNode{
+			mode: 0644,
+			modtime: zf.Modified,
+			createtime: zf.Modified,
+			size: int64(zf.UncompressedSize64),
+		}
+		off, e := zf.DataOffset()
+		if zf.Method == zip.Store && e == nil {
+			n.data = io.NewSectionReader(f, off, n.size)
+		} else {
+			n.load = func() ([]byte, error) {
+				if zf.UncompressedSize64 > maxMemFile {
+					return nil, fmt.Errorf("too big to unpack into memory")
+				}
+				r, err := zf.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				return io.ReadAll(r)
+			}
+		}
+		fsys.addNode(zf.Name, n)
+	}
+	closer = f
+	return
+}
+
+// Keeps track of the offset in the archive, so that
+// we know where the data of a tar member starts.
+type offsetReader struct {
+	f		*os.File
+	off		int64
+}
+
+func (r *offsetReader) Read(b []byte) (n int, err error) {
+	n, err = r.f.Read(b)
+	r.off += int64(n)
+	return
+}
+
+func (r *offsetReader) Seek(offset int64, whence int) (n int64, err error) {
+	n, err = r.f.Seek(offset, whence)
+	if err == nil {
+		r.off = n
+	}
+	return
+}
+
+// Tar archives (not compressed, that cannot seek). The files
+// are read straight from the archive.
+func openTarFS(fn string) (fsys *MemFS, closer io.Closer, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	r := &offsetReader{ f: f }
+	tr := tar.NewReader(r)
+	fsys = NewMemFS()
+	for {
+		hdr, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			f.Close()
+			err = e
+			return
+		}
+		// creation time: the oldest of the times in the header.
+		ctime := hdr.ModTime
+		for _, t := range []time.Time{ hdr.ChangeTime, hdr.AccessTime } {
+			if !t.IsZero() && t.Before(ctime) {
+				ctime = t
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fsys.addNode(hdr.Name, &memNode{ mode: fs.ModeDir | 0755,
+				modtime: hdr.ModTime, createtime: ctime })
+		case tar.TypeReg, tar.TypeRegA:
+			fsys.addNode(hdr.Name, &memNode{
+				mode: 0644,
+				modtime: hdr.ModTime,
+				createtime: ctime,
+				size: hdr.Size,
+				data: io.NewSectionReader(f, r.off, hdr.Size),
+			})
+		}
+	}
+	closer = f
+	return
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+var testMovieFiles = map[string]string{
+	"Alien (1979)/Alien (1979).mp4":	"video",
+	"Alien (1979)/Alien (1979).nfo":	"<movie><title>Alien NFO</title><year>1979</year></movie>",
+	"Alien (1979)/poster.jpg":		"jpeg",
+	"Heat (1995)/Heat (1995).mp4":		"video",
+	"Heat (1995)/Heat (1995).en.srt":	"subs",
+	"Empty/readme.txt":			"no video here",
+}
+
+var testShowFiles = map[string]string{
+	"Show/tvshow.nfo":		"<tvshow><title>Show NFO</title></tvshow>",
+	"Show/poster.jpg":		"jpeg",
+	"Show/S1/Show.S01E01.mp4":	"video",
+	"Show/S1/Show.S01E02.mp4":	"video",
+	"Show/S1/Show.S01E02.nfo":	"<episodedetails><title>Two</title></episodedetails>",
+	"Show/S2/Show.S02E01.mp4":	"video",
+}
+
+// A fresh database for every test.
+func testDb(t *testing.T) {
+	if dbHandle != nil {
+		dbHandle.Close()
+	}
+	err := dbInit(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testMemFS(files map[string]string) (m *MemFS) {
+	m = NewMemFS()
+	for name, data := range files {
+		m.AddFile(name, []byte(data), testTime)
+	}
+	return
+}
+
+// Mount an in-memory tree and return the directory it is on.
+func testMountMem(t *testing.T, files map[string]string) string {
+	dir := "/mem/" + t.Name()
+	mountFS(dir, testMemFS(files))
+	t.Cleanup(func() {
+		vfsMountsLock.Lock()
+		delete(vfsMounts, dir)
+		vfsMountsLock.Unlock()
+	})
+	return dir
+}
+
+func testZip(t *testing.T, files map[string]string, method uint16) string {
+	fn := filepath.Join(t.TempDir(), "coll.zip")
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name: name,
+			Method: method,
+			Modified: testTime,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return fn
+}
+
+func testTar(t *testing.T, files map[string]string) string {
+	fn := filepath.Join(t.TempDir(), "coll.tar")
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for name, data := range files {
+		err = tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(data)),
+			ModTime: testTime,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data))
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return fn
+}
+
+func TestScanFS(t *testing.T) {
+	tests := []struct {
+		name		string
+		typ		string
+		mount		func(t *testing.T, files map[string]string) string
+	}{
+		{ "memfs", "movies", testMountMem },
+		{ "memfs", "shows", testMountMem },
+		{ "zip-stored", "movies", func(t *testing.T, files map[string]string) string {
+			return testZip(t, files, zip.Store)
+		}},
+		{ "zip-deflated", "movies", func(t *testing.T, files map[string]string) string {
+			return testZip(t, files, zip.Deflate)
+		}},
+		{ "zip-deflated", "shows", func(t *testing.T, files map[string]string) string {
+			return testZip(t, files, zip.Deflate)
+		}},
+		{ "tar", "movies", testTar },
+		{ "tar", "shows", testTar },
+	}
+	for _, tt := range tests {
+		t.Run(tt.name + "/" + tt.typ, func(t *testing.T) {
+			testDb(t)
+			files := testMovieFiles
+			if tt.typ == "shows" {
+				files = testShowFiles
+			}
+			coll := &Collection{
+				Name_: "test",
+				Type: tt.typ,
+				Directory: tt.mount(t, files),
+			}
+			if err := coll.mount(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				vfsMountsLock.Lock()
+				delete(vfsMounts, path.Clean(coll.Directory))
+				vfsMountsLock.Unlock()
+			})
+			if tt.typ == "movies" {
+				testCheckMovies(t, buildMovies(coll, 0))
+			} else {
+				testCheckShows(t, buildShows(coll, 0))
+			}
+		})
+	}
+}
+
+func testCheckMovies(t *testing.T, items []*Item) {
+	sort.Slice(items, func(a, b int) bool { return items[a].Name < items[b].Name })
+	if len(items) != 2 {
+		t.Fatalf("got %d movies, want 2", len(items))
+	}
+	alien, heat := items[0], items[1]
+	if alien.Name != "Alien (1979)" || alien.Video != "Alien%20%281979%29.mp4" {
+		t.Errorf("alien: name %q video %q", alien.Name, alien.Video)
+	}
+	if alien.Title != "Alien NFO" || alien.Year != 1979 {
+		t.Errorf("alien: NFO not read: title %q year %d", alien.Title, alien.Year)
+	}
+	if alien.Poster != "poster.jpg" {
+		t.Errorf("alien: poster %q", alien.Poster)
+	}
+	if heat.Name != "Heat (1995)" || len(heat.SrtSubs) != 1 {
+		t.Errorf("heat: name %q subs %v", heat.Name, heat.SrtSubs)
+	}
+	if alien.Id == "" || alien.Id == heat.Id {
+		t.Errorf("ids: %q %q", alien.Id, heat.Id)
+	}
+}
+
+func testCheckShows(t *testing.T, items []*Item) {
+	if len(items) != 1 {
+		t.Fatalf("got %d shows, want 1", len(items))
+	}
+	show := items[0]
+	if show.Title != "Show NFO" {
+		t.Errorf("title %q", show.Title)
+	}
+	if len(show.Seasons) != 2 {
+		t.Fatalf("got %d seasons, want 2", len(show.Seasons))
+	}
+	want := []int{ 2, 1 }
+	for i, s := range show.Seasons {
+		if s.SeasonNo != i + 1 || len(s.Episodes) != want[i] {
+			t.Errorf("season %d: %d episodes", s.SeasonNo, len(s.Episodes))
+		}
+	}
+	ep := show.Seasons[0].Episodes[1]
+	if ep.EpisodeNo != 2 || ep.NfoPath == "" {
+		t.Errorf("episode 2: %+v", ep)
+	}
+	if nfo := loadNfo(ep.NfoPath); nfo == nil || nfo.Title != "Two" {
+		t.Errorf("episode 2: NFO not readable")
+	}
+}
+
+// Every open of a compressed member has its own data.
+func TestZipOpen(t *testing.T) {
+	fsys, closer, err := openZipFS(testZip(t, testMovieFiles, zip.Deflate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	name := "Alien (1979)/Alien (1979).nfo"
+	f1, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, _ := fsys.Open(name)
+	f1.Close()
+	b := make([]byte, 7)
+	if _, err := f2.Read(b); err != nil || string(b) != "<movie>" {
+		t.Errorf("read after other file closed: %q %v", b, err)
+	}
+	if fsys.nodes[name].data != nil {
+		t.Errorf("data kept in the node")
+	}
+}
+
+// A collection served from its snapshot is mounted too.
+func TestInitCollectionsMount(t *testing.T) {
+	testDb(t)
+	saved := config.Collections
+	defer func() { config.Collections = saved }()
+
+	zipFile := testZip(t, testMovieFiles, zip.Store)
+	unmount := func() {
+		vfsMountsLock.Lock()
+		delete(vfsMounts, zipFile)
+		vfsMountsLock.Unlock()
+	}
+	defer unmount()
+
+	config.Collections = []Collection{{
+		Name_: "test",
+		Type: "movies",
+		Directory: zipFile,
+	}}
+	c := &config.Collections[0]
+	updateCollection(c, 1, 0)
+	unmount()
+
+	c.Items = nil
+	initCollections()
+	if len(c.Items) != 2 {
+		t.Fatalf("got %d items from the snapshot, want 2", len(c.Items))
+	}
+	if !isMounted(zipFile + "/Heat (1995)/Heat (1995).mp4") {
+		t.Errorf("collection not mounted")
+	}
+}
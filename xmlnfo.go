@@ -3,7 +3,7 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"io"
 	"strings"
 	"encoding/xml"
@@ -12,6 +12,7 @@ import (
 type Nfo struct {
 	Title		string		`xml:"title,omitempty" json:"title,omitempty"`
 	Id		string		`xml:"id,omitempty" json:"id,omitempty"`
+	UniqueId	[]UniqueId	`xml:"uniqueid,omitempty" json:"uniqueid,omitempty"`
 	Runtime		string		`xml:"runtime,omitempty" json:"runtime,omitempty"`
 	Mpaa		string		`xml:"mpaa,omitempty" json:"mpaa,omitempty"`
 	YearString	string		`xml:"year,omitempty" json:"-"`
@@ -44,6 +45,12 @@ type Thumb struct {
 	Thumb		string		`xml:"thumb,omitempty" json:"thumb,omitempty"`
 }
 
+type UniqueId struct {
+	Type		string		`xml:"type,attr,omitempty" json:"type,omitempty"`
+	Default		string		`xml:"default,attr,omitempty" json:"default,omitempty"`
+	Value		string		`xml:",chardata" json:"value"`
+}
+
 type Actor struct {
 	Name		string		`xml:"name,omitempty" json:"name,omitempty"`
 	Role		string		`xml:"role,omitempty" json:"role,omitempty"`
@@ -64,13 +71,32 @@ type VideoDetails struct {
 }
 */
 
+// Open and decode an NFO file.
+func loadNfo(fn string) (nfo *Nfo) {
+	file, err := vfsOpen(fn)
+	if err == nil {
+		nfo = decodeNfo(file)
+		file.Close()
+	}
+	return
+}
+
 func decodeNfo(r io.ReadSeeker) (nfo *Nfo) {
+	nfo, _ = parseNfo(r)
+	return
+}
+
+func parseNfo(r io.ReadSeeker) (nfo *Nfo, err error) {
 	// this is a really dirty hack to partially support <xbmcmultiepisode>
 	// for now. It just skips the tag and as a result parses just
 	// the first episode in the multiepisode list.
 	buf, err := io.ReadAll(r)
-	if err != nil || len(buf) < 18 {
-		return nil
+	if err != nil {
+		return
+	}
+	if len(buf) < 18 {
+		err = errors.New("file too short")
+		return
 	}
 	if string(buf[:18]) == "<xbmcmultiepisode>" {
 		buf = buf[18:]
@@ -88,7 +114,6 @@ func decodeNfo(r io.ReadSeeker) (nfo *Nfo) {
 	err = d.Decode(data)
 	// fmt.Printf("data: %+v\nxmlData: %s\n", data, string(xmlData))
 	if err != nil {
-		fmt.Println("Error unmarshalling from XML %v, %v", err, nfo)
 		return
 	}
 
@@ -127,3 +152,23 @@ func decodeNfo(r io.ReadSeeker) (nfo *Nfo) {
 	return
 }
 
+// The unique ids (imdb, tmdb, tvdb ..) by type. Older NFO files
+// only have <id>, which for movies is usually the IMDb id.
+func (nfo *Nfo) uniqueIds() (ids map[string]string) {
+	ids = make(map[string]string)
+	for _, u := range nfo.UniqueId {
+		t := strings.ToLower(strings.TrimSpace(u.Type))
+		v := strings.TrimSpace(u.Value)
+		if t == "" {
+			t = "unknown"
+		}
+		if v != "" {
+			ids[t] = v
+		}
+	}
+	id := strings.TrimSpace(nfo.Id)
+	if ids["imdb"] == "" && strings.HasPrefix(id, "tt") {
+		ids["imdb"] = id
+	}
+	return
+}